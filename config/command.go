@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Command is an entry of a model's comments or commands. In model.yaml it
+// is either a plain string or an object with per-command settings:
+//
+//	commands:
+//	  - show running-config
+//	  - cmd: show tech-support
+//	    timeout: 10m
+//	    optional: true
+//	    on_error: warn
+type Command struct {
+	Cmd string `yaml:"cmd"`
+	// Timeout replaces the device timeout while waiting for the output
+	Timeout time.Duration `yaml:"timeout"`
+	// Prompt replaces the model prompt for this command, e.g. one that
+	// enters a different CLI mode
+	Prompt string `yaml:"prompt"`
+	// Expect rules are applied before the model's
+	Expect []ExpectRule `yaml:"expect"`
+	// Optional commands may fail without failing the backup
+	Optional bool `yaml:"optional"`
+	// OnError is the policy when the output matches one of the model's
+	// errors patterns: fail (default), warn or skip
+	OnError     string `yaml:"on_error"`
+	promptRegex *regexp.Regexp
+}
+
+// Policies for command output matching a model's errors patterns
+const (
+	// OnErrorFail fails the device
+	OnErrorFail = "fail"
+	// OnErrorWarn logs a warning and keeps the output
+	OnErrorWarn = "warn"
+	// OnErrorSkip logs a warning and leaves the command's output out
+	OnErrorSkip = "skip"
+)
+
+// UnmarshalYAML accepts a plain string as well as the object form
+func (c *Command) UnmarshalYAML(unmarshal func(any) error) error {
+	var cmd string
+	if err := unmarshal(&cmd); err == nil {
+		*c = Command{Cmd: cmd}
+		return nil
+	}
+
+	type plain Command
+	return unmarshal((*plain)(c))
+}
+
+// String returns the command line
+func (c *Command) String() string {
+	return c.Cmd
+}
+
+// EffectiveTimeout returns the command's timeout, or def if it has none
+func (c *Command) EffectiveTimeout(def time.Duration) time.Duration {
+	if c.Timeout == 0 {
+		return def
+	}
+	return c.Timeout
+}
+
+// EffectiveOnError returns the error policy, defaulting to fail
+func (c *Command) EffectiveOnError() string {
+	if c.OnError == "" {
+		return OnErrorFail
+	}
+	return c.OnError
+}
+
+// PromptRegex returns the compiled prompt regex, or nil if the command
+// uses the model prompt
+func (c *Command) PromptRegex() (*regexp.Regexp, error) {
+	if c.Prompt == "" {
+		return nil, nil
+	}
+	if c.promptRegex == nil {
+		re, err := regexp.Compile(c.Prompt)
+		if err != nil {
+			return nil, fmt.Errorf("compile prompt pattern %q: %w", c.Prompt, err)
+		}
+		c.promptRegex = re
+	}
+	return c.promptRegex, nil
+}
+
+// validateCommands checks the entries of a comments or commands list
+func validateCommands(field string, cmds []Command) error {
+	for i := range cmds {
+		c := &cmds[i]
+		if c.Cmd == "" {
+			return fmt.Errorf("%s[%d]: cmd is empty", field, i)
+		}
+		if c.Timeout < 0 {
+			return fmt.Errorf("%s[%d]: timeout must not be negative", field, i)
+		}
+		switch c.OnError {
+		case "", OnErrorFail, OnErrorWarn, OnErrorSkip:
+		default:
+			return fmt.Errorf("%s[%d]: unknown on_error %q", field, i, c.OnError)
+		}
+		if _, err := c.PromptRegex(); err != nil {
+			return fmt.Errorf("%s[%d]: %w", field, i, err)
+		}
+		for j := range c.Expect {
+			if _, err := c.Expect[j].Regex(); err != nil {
+				return fmt.Errorf("%s[%d] expect[%d]: %w", field, i, j, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPathSegment is one step of a JSON path
+type JSONPathSegment struct {
+	// Key is the object member name (unused with Wildcard or Index)
+	Key string
+	// Index is the array element when IsIndex is set
+	Index   int
+	IsIndex bool
+	// Wildcard matches every member or element
+	Wildcard bool
+	// Recursive matches at any depth below the current node (..)
+	Recursive bool
+}
+
+// JSONPath is a parsed JSON path supporting $, .key, ..key, .*, [n], [*] and ['key']
+type JSONPath []JSONPathSegment
+
+// ParseJSONPath parses a JSON path expression
+func ParseJSONPath(expr string) (JSONPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("json path %q must start with $", expr)
+	}
+
+	var path JSONPath
+	rest := expr[1:]
+
+	for rest != "" {
+		var seg JSONPathSegment
+		dot := ""
+		if strings.HasPrefix(rest, "..") {
+			seg.Recursive = true
+			dot, rest = "..", rest[2:]
+		} else if strings.HasPrefix(rest, ".") {
+			dot, rest = ".", rest[1:]
+		}
+
+		if strings.HasPrefix(rest, "[") && dot != "." {
+			// Bracket notation, on its own or after ..
+			remaining, err := parseJSONPathBracket(&seg, expr, rest)
+			if err != nil {
+				return nil, err
+			}
+			rest = remaining
+		} else if dot != "" {
+			name, remaining := splitJSONPathName(rest)
+			if name == "" {
+				return nil, fmt.Errorf("json path %q: missing name after %s", expr, dot)
+			}
+			setJSONPathName(&seg, name)
+			rest = remaining
+		} else {
+			return nil, fmt.Errorf("json path %q: unexpected %q", expr, rest)
+		}
+		path = append(path, seg)
+	}
+
+	if len(path) == 0 {
+		return nil, fmt.Errorf("json path %q selects the whole document", expr)
+	}
+	return path, nil
+}
+
+// parseJSONPathBracket parses the [*], ['key'] or [n] at the start of rest
+// into seg and returns what follows it
+func parseJSONPathBracket(seg *JSONPathSegment, expr, rest string) (string, error) {
+	end := strings.Index(rest, "]")
+	if end < 0 {
+		return "", fmt.Errorf("json path %q: unterminated [", expr)
+	}
+	inner := strings.TrimSpace(rest[1:end])
+
+	switch {
+	case inner == "*":
+		seg.Wildcard = true
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		seg.Key = inner[1 : len(inner)-1]
+	default:
+		n, err := strconv.Atoi(inner)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("json path %q: invalid index %q", expr, inner)
+		}
+		seg.Index = n
+		seg.IsIndex = true
+	}
+	return rest[end+1:], nil
+}
+
+// splitJSONPathName splits a dotted member name from the rest of the path
+func splitJSONPathName(s string) (string, string) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+func setJSONPathName(seg *JSONPathSegment, name string) {
+	if name == "*" {
+		seg.Wildcard = true
+	} else {
+		seg.Key = name
+	}
+}
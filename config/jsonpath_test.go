@@ -0,0 +1,57 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr string
+		want JSONPath
+		err  string
+	}{
+		{expr: "$.a", want: JSONPath{{Key: "a"}}},
+		{expr: "$.a.b", want: JSONPath{{Key: "a"}, {Key: "b"}}},
+		{expr: "$..password", want: JSONPath{{Key: "password", Recursive: true}}},
+		{expr: "$.users.*", want: JSONPath{{Key: "users"}, {Wildcard: true}}},
+		{expr: "$..*", want: JSONPath{{Wildcard: true, Recursive: true}}},
+		{expr: "$.users[0]", want: JSONPath{{Key: "users"}, {Index: 0, IsIndex: true}}},
+		{expr: "$.users[12].secret", want: JSONPath{{Key: "users"}, {Index: 12, IsIndex: true}, {Key: "secret"}}},
+		{expr: "$.users[*].secret", want: JSONPath{{Key: "users"}, {Wildcard: true}, {Key: "secret"}}},
+		{expr: "$['a.b']", want: JSONPath{{Key: "a.b"}}},
+		{expr: `$["a b"]`, want: JSONPath{{Key: "a b"}}},
+		{expr: "$[ 'a' ]", want: JSONPath{{Key: "a"}}},
+		{expr: "$..[0]", want: JSONPath{{Index: 0, IsIndex: true, Recursive: true}}},
+		{expr: "$..['key']", want: JSONPath{{Key: "key", Recursive: true}}},
+		{expr: "a.b", err: "must start with $"},
+		{expr: "$", err: "selects the whole document"},
+		{expr: "$.", err: "missing name after ."},
+		{expr: "$..", err: "missing name after .."},
+		{expr: "$.[0]", err: "missing name after ."},
+		{expr: "$a", err: `unexpected "a"`},
+		{expr: "$[0", err: "unterminated ["},
+		{expr: "$[-1]", err: "invalid index"},
+		{expr: "$[x]", err: "invalid index"},
+		{expr: "$['a\"]", err: "invalid index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := ParseJSONPath(tt.expr)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
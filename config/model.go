@@ -16,21 +16,223 @@ type ModelFile struct {
 // Model represents a device model definition
 type Model struct {
 	Prompt      string           `yaml:"prompt"`
+	Mode        string           `yaml:"mode"`
 	Comment     string           `yaml:"comment"`
 	Connection  ConnectionConfig `yaml:"connection"`
+	SSH         SSHConfig        `yaml:"ssh"`
+	Terminal    TerminalConfig   `yaml:"terminal"`
+	Login       LoginConfig      `yaml:"login"`
+	Netconf     NetconfConfig    `yaml:"netconf"`
+	JSONRPC     JSONRPCConfig    `yaml:"jsonrpc"`
+	Restconf    RestconfConfig   `yaml:"restconf"`
 	Expect      []ExpectRule     `yaml:"expect"`
 	Secrets     []FilterRule     `yaml:"secrets"`
-	Comments    []string         `yaml:"comments"`
-	Commands    []string         `yaml:"commands"`
+	Errors      []string         `yaml:"errors"`
+	Comments    []Command        `yaml:"comments"`
+	Commands    []Command        `yaml:"commands"`
+	Files       []string         `yaml:"files"`
+	FileOutput  string           `yaml:"file_output"`
 	promptRegex *regexp.Regexp
+	errorRegex  []*regexp.Regexp
 }
 
+// SSH session modes
+const (
+	// ModeShell runs commands in an interactive shell on a PTY (default)
+	ModeShell = "shell"
+	// ModeExec runs each command on its own exec channel without a PTY
+	ModeExec = "exec"
+)
+
 // ConnectionConfig represents connection settings
 type ConnectionConfig struct {
 	PostLogin []string `yaml:"post_login"`
 	PreLogout string   `yaml:"pre_logout"`
 }
 
+// Default PTY settings for SSH shell sessions
+const (
+	DefaultTerminalType   = "xterm"
+	DefaultTerminalWidth  = 200
+	DefaultTerminalHeight = 80
+)
+
+// TerminalConfig represents the PTY requested for SSH shell sessions, the
+// terminal advertised on Telnet, and the cleanup of terminal output
+type TerminalConfig struct {
+	// Type is the terminal type (default: xterm)
+	Type string `yaml:"type"`
+	// Width and Height are the terminal size in characters (default: 200x80)
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+	// Normalize strips escape sequences, applies backspaces and carriage
+	// return overwrites, and converts line endings to \n in command output
+	Normalize bool `yaml:"normalize"`
+}
+
+// EffectiveType returns the terminal type, defaulting to xterm
+func (t *TerminalConfig) EffectiveType() string {
+	if t.Type == "" {
+		return DefaultTerminalType
+	}
+	return t.Type
+}
+
+// EffectiveSize returns the terminal width and height, defaulting to 200x80
+func (t *TerminalConfig) EffectiveSize() (width, height int) {
+	width, height = t.Width, t.Height
+	if width == 0 {
+		width = DefaultTerminalWidth
+	}
+	if height == 0 {
+		height = DefaultTerminalHeight
+	}
+	return width, height
+}
+
+// LoginConfig represents the login dialogue for transports without
+// built-in authentication (e.g. Telnet), and the enable password prompt
+type LoginConfig struct {
+	UsernamePrompt string `yaml:"username_prompt"`
+	PasswordPrompt string `yaml:"password_prompt"`
+	EnablePrompt   string `yaml:"enable_prompt"`
+	usernameRegex  *regexp.Regexp
+	passwordRegex  *regexp.Regexp
+	enableRegex    *regexp.Regexp
+}
+
+// Default login prompt patterns
+const (
+	DefaultUsernamePrompt = `(?i)(user ?name|login)\s*:\s*$`
+	DefaultPasswordPrompt = `(?i)password\s*:\s*$`
+)
+
+// UsernameRegex returns the compiled username prompt regex
+func (l *LoginConfig) UsernameRegex() (*regexp.Regexp, error) {
+	if l.usernameRegex == nil {
+		pattern := l.UsernamePrompt
+		if pattern == "" {
+			pattern = DefaultUsernamePrompt
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile username_prompt pattern %q: %w", pattern, err)
+		}
+		l.usernameRegex = re
+	}
+	return l.usernameRegex, nil
+}
+
+// PasswordRegex returns the compiled password prompt regex
+func (l *LoginConfig) PasswordRegex() (*regexp.Regexp, error) {
+	if l.passwordRegex == nil {
+		pattern := l.PasswordPrompt
+		if pattern == "" {
+			pattern = DefaultPasswordPrompt
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("compile password_prompt pattern %q: %w", pattern, err)
+		}
+		l.passwordRegex = re
+	}
+	return l.passwordRegex, nil
+}
+
+// EnableRegex returns the compiled enable prompt regex, or nil if not configured
+func (l *LoginConfig) EnableRegex() (*regexp.Regexp, error) {
+	if l.EnablePrompt == "" {
+		return nil, nil
+	}
+	if l.enableRegex == nil {
+		re, err := regexp.Compile(l.EnablePrompt)
+		if err != nil {
+			return nil, fmt.Errorf("compile enable_prompt pattern %q: %w", l.EnablePrompt, err)
+		}
+		l.enableRegex = re
+	}
+	return l.enableRegex, nil
+}
+
+// Output formats for structured (XML or JSON) transports
+const (
+	FormatRaw       = "raw"
+	FormatPretty    = "pretty"
+	FormatCanonical = "canonical"
+)
+
+// NetconfConfig represents NETCONF settings. With the netconf transport,
+// each entry in commands names a datastore (running, candidate, startup)
+// or is a raw RPC body starting with "<".
+type NetconfConfig struct {
+	// Format is the XML output format: raw (default), pretty or canonical
+	Format string `yaml:"format"`
+}
+
+// RESTCONF encodings
+const (
+	EncodingJSON = "json"
+	EncodingXML  = "xml"
+)
+
+// RestconfConfig represents RESTCONF settings. With the restconf transport,
+// each entry in commands is a YANG path below the data resource
+// (e.g. "ietf-interfaces:interfaces"), or "/" for the whole datastore.
+type RestconfConfig struct {
+	// Encoding is json (default) or xml
+	Encoding string `yaml:"encoding"`
+	// Format is the output format: raw (default), pretty or canonical
+	Format string `yaml:"format"`
+}
+
+// EffectiveEncoding returns the encoding to request, defaulting to JSON
+func (r *RestconfConfig) EffectiveEncoding() string {
+	if r.Encoding == "" {
+		return EncodingJSON
+	}
+	return r.Encoding
+}
+
+// DefaultJSONRPCPath is the Arista eAPI endpoint
+const DefaultJSONRPCPath = "/command-api"
+
+// JSONRPCConfig represents settings for the http-jsonrpc transport. Each
+// command is sent as runCmds with text format, preceded by the post_login
+// commands since the API is stateless.
+type JSONRPCConfig struct {
+	// Path is the API endpoint (default: /command-api)
+	Path string `yaml:"path"`
+}
+
+// EffectivePath returns the endpoint path, defaulting to /command-api
+func (j *JSONRPCConfig) EffectivePath() string {
+	if j.Path == "" {
+		return DefaultJSONRPCPath
+	}
+	return j.Path
+}
+
+// File output modes
+const (
+	// FileOutputAppend appends retrieved files to the device backup
+	FileOutputAppend = "append"
+	// FileOutputSeparate saves retrieved files next to the device backup
+	FileOutputSeparate = "separate"
+)
+
+// EffectiveFileOutput returns how retrieved files are stored, defaulting to append
+func (m *Model) EffectiveFileOutput() string {
+	if m.FileOutput == "" {
+		return FileOutputAppend
+	}
+	return m.FileOutput
+}
+
+// HasCommands reports whether the model runs any CLI commands
+func (m *Model) HasCommands() bool {
+	return len(m.Comments) > 0 || len(m.Commands) > 0
+}
+
 // ExpectRule represents an expect/response rule for interactive handling
 type ExpectRule struct {
 	Pattern string `yaml:"pattern"`
@@ -51,11 +253,27 @@ func (e *ExpectRule) Regex() (*regexp.Regexp, error) {
 	return e.regex, nil
 }
 
-// FilterRule represents a pattern replacement rule (for secrets or filters)
+// FilterRule represents a pattern replacement rule (for secrets or filters).
+// A rule with JSONPath instead of Pattern replaces the selected values of
+// JSON output with Replace.
 type FilterRule struct {
-	Pattern string `yaml:"pattern"`
-	Replace string `yaml:"replace"`
-	regex   *regexp.Regexp
+	Pattern  string `yaml:"pattern"`
+	JSONPath string `yaml:"json_path"`
+	Replace  string `yaml:"replace"`
+	regex    *regexp.Regexp
+	path     JSONPath
+}
+
+// Path returns the parsed JSON path for this rule
+func (f *FilterRule) Path() (JSONPath, error) {
+	if f.path == nil {
+		p, err := ParseJSONPath(f.JSONPath)
+		if err != nil {
+			return nil, err
+		}
+		f.path = p
+	}
+	return f.path, nil
 }
 
 // Regex returns the compiled regex for this rule
@@ -82,6 +300,24 @@ func (m *Model) PromptRegex() (*regexp.Regexp, error) {
 	return m.promptRegex, nil
 }
 
+// ErrorRegexes returns the compiled errors patterns, which detect
+// device-side command errors in the output. They are compiled in
+// multi-line mode, so that ^ and $ match at line boundaries.
+func (m *Model) ErrorRegexes() ([]*regexp.Regexp, error) {
+	if m.errorRegex == nil && len(m.Errors) > 0 {
+		regexes := make([]*regexp.Regexp, len(m.Errors))
+		for i, pattern := range m.Errors {
+			re, err := regexp.Compile("(?m)" + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("compile errors pattern %q: %w", pattern, err)
+			}
+			regexes[i] = re
+		}
+		m.errorRegex = regexes
+	}
+	return m.errorRegex, nil
+}
+
 // LoadModelFile loads and parses model.yaml
 func LoadModelFile(path string) (*ModelFile, error) {
 	data, err := os.ReadFile(path)
@@ -107,34 +343,118 @@ func validateModelFile(mf *ModelFile) error {
 	}
 
 	for name, m := range mf.Models {
-		if m.Prompt == "" {
-			return fmt.Errorf("model %q: prompt is required", name)
+		// Prompt is only needed by CLI transports, which check for it on connect
+		if _, err := m.PromptRegex(); err != nil {
+			return fmt.Errorf("model %q: %w", name, err)
 		}
 
-		// Validate prompt regex compiles
-		if _, err := m.PromptRegex(); err != nil {
+		switch m.Mode {
+		case "", ModeShell, ModeExec:
+		default:
+			return fmt.Errorf("model %q: unknown mode %q", name, m.Mode)
+		}
+
+		if err := validateSSHConfig(&m.SSH); err != nil {
 			return fmt.Errorf("model %q: %w", name, err)
 		}
 
+		// Telnet sends the size as 16-bit values
+		if m.Terminal.Width < 0 || m.Terminal.Height < 0 || m.Terminal.Width > 65535 || m.Terminal.Height > 65535 {
+			return fmt.Errorf("model %q: terminal width and height must be between 0 and 65535", name)
+		}
+
+		if !validFormat(m.Netconf.Format) {
+			return fmt.Errorf("model %q: unknown netconf format %q", name, m.Netconf.Format)
+		}
+		if !validFormat(m.Restconf.Format) {
+			return fmt.Errorf("model %q: unknown restconf format %q", name, m.Restconf.Format)
+		}
+		switch m.Restconf.Encoding {
+		case "", EncodingJSON, EncodingXML:
+		default:
+			return fmt.Errorf("model %q: unknown restconf encoding %q", name, m.Restconf.Encoding)
+		}
+
+		// Validate login patterns
+		if _, err := m.Login.UsernameRegex(); err != nil {
+			return fmt.Errorf("model %q login: %w", name, err)
+		}
+		if _, err := m.Login.PasswordRegex(); err != nil {
+			return fmt.Errorf("model %q login: %w", name, err)
+		}
+		if _, err := m.Login.EnableRegex(); err != nil {
+			return fmt.Errorf("model %q login: %w", name, err)
+		}
+
 		// Validate expect patterns
-		for i, e := range m.Expect {
-			if _, err := e.Regex(); err != nil {
+		for i := range m.Expect {
+			if _, err := m.Expect[i].Regex(); err != nil {
 				return fmt.Errorf("model %q expect[%d]: %w", name, i, err)
 			}
 		}
 
 		// Validate secret patterns
-		for i, s := range m.Secrets {
-			if _, err := s.Regex(); err != nil {
-				return fmt.Errorf("model %q secrets[%d]: %w", name, i, err)
+		for i := range m.Secrets {
+			s := &m.Secrets[i]
+			switch {
+			case s.Pattern != "" && s.JSONPath != "":
+				return fmt.Errorf("model %q secrets[%d]: pattern and json_path are mutually exclusive", name, i)
+			case s.JSONPath != "" && m.Restconf.EffectiveEncoding() == EncodingXML:
+				return fmt.Errorf("model %q secrets[%d]: json_path needs JSON output, but restconf.encoding is xml", name, i)
+			case s.JSONPath != "":
+				if _, err := s.Path(); err != nil {
+					return fmt.Errorf("model %q secrets[%d]: %w", name, i, err)
+				}
+			default:
+				if _, err := s.Regex(); err != nil {
+					return fmt.Errorf("model %q secrets[%d]: %w", name, i, err)
+				}
 			}
 		}
 
-		// Validate at least one command is defined
-		if len(m.Commands) == 0 {
-			return fmt.Errorf("model %q: at least one command is required", name)
+		// Validate error patterns
+		for i, pattern := range m.Errors {
+			if pattern == "" {
+				return fmt.Errorf("model %q errors[%d]: pattern is empty", name, i)
+			}
+		}
+		if _, err := m.ErrorRegexes(); err != nil {
+			return fmt.Errorf("model %q: %w", name, err)
+		}
+
+		// Validate commands
+		if err := validateCommands("comments", m.Comments); err != nil {
+			return fmt.Errorf("model %q %w", name, err)
+		}
+		if err := validateCommands("commands", m.Commands); err != nil {
+			return fmt.Errorf("model %q %w", name, err)
+		}
+
+		// Validate files
+		switch m.FileOutput {
+		case "", FileOutputAppend, FileOutputSeparate:
+		default:
+			return fmt.Errorf("model %q: unknown file_output %q", name, m.FileOutput)
+		}
+		for i, f := range m.Files {
+			if f == "" {
+				return fmt.Errorf("model %q files[%d]: path is empty", name, i)
+			}
+		}
+
+		// Validate at least one command or file is defined
+		if len(m.Commands) == 0 && len(m.Files) == 0 {
+			return fmt.Errorf("model %q: at least one command or file is required", name)
 		}
 	}
 
 	return nil
 }
+
+func validFormat(format string) bool {
+	switch format {
+	case "", FormatRaw, FormatPretty, FormatCanonical:
+		return true
+	}
+	return false
+}
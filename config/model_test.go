@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateModelFileSecrets(t *testing.T) {
+	tests := []struct {
+		name     string
+		restconf RestconfConfig
+		secret   FilterRule
+		err      string
+	}{
+		{
+			name:   "json_path",
+			secret: FilterRule{JSONPath: "$..password"},
+		},
+		{
+			name:     "json_path with json encoding",
+			restconf: RestconfConfig{Encoding: EncodingJSON},
+			secret:   FilterRule{JSONPath: "$..password"},
+		},
+		{
+			name:     "json_path with xml encoding",
+			restconf: RestconfConfig{Encoding: EncodingXML},
+			secret:   FilterRule{JSONPath: "$..password"},
+			err:      `model "m" secrets[0]: json_path needs JSON output, but restconf.encoding is xml`,
+		},
+		{
+			name:     "pattern with xml encoding",
+			restconf: RestconfConfig{Encoding: EncodingXML},
+			secret:   FilterRule{Pattern: `(<password>)[^<]*`},
+		},
+		{
+			name:   "pattern and json_path",
+			secret: FilterRule{Pattern: "password", JSONPath: "$..password"},
+			err:    "mutually exclusive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mf := &ModelFile{Models: map[string]*Model{
+				"m": {
+					Restconf: tt.restconf,
+					Secrets:  []FilterRule{tt.secret},
+					Commands: []Command{{Cmd: "Cisco-IOS-XE-native:native"}},
+				},
+			}}
+			err := validateModelFile(mf)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryConfig represents how a device backup that failed with a transient
+// error (dial failure, timeout, connection reset) is retried. The delay
+// before each retry starts at Backoff and doubles up to MaxBackoff, varied
+// by up to Jitter (a fraction) either way. Fields left nil are inherited
+// when merged.
+type RetryConfig struct {
+	// Retries is the number of attempts after the first one
+	Retries *int `yaml:"retries"`
+	// Backoff is the delay before the first retry
+	Backoff *time.Duration `yaml:"backoff"`
+	// MaxBackoff caps the delay; 0 leaves it at MaxRetryDelay
+	MaxBackoff *time.Duration `yaml:"max_backoff"`
+	// Jitter randomizes each delay by up to this fraction, e.g. 0.2 for ±20%
+	Jitter *float64 `yaml:"jitter"`
+}
+
+// Merge returns c with the fields set in override replacing its own
+func (c RetryConfig) Merge(override RetryConfig) RetryConfig {
+	if override.Retries != nil {
+		c.Retries = override.Retries
+	}
+	if override.Backoff != nil {
+		c.Backoff = override.Backoff
+	}
+	if override.MaxBackoff != nil {
+		c.MaxBackoff = override.MaxBackoff
+	}
+	if override.Jitter != nil {
+		c.Jitter = override.Jitter
+	}
+	return c
+}
+
+// EffectiveRetries returns the number of retries, defaulting to none
+func (c *RetryConfig) EffectiveRetries() int {
+	if c.Retries == nil {
+		return 0
+	}
+	return *c.Retries
+}
+
+// MaxRetryDelay caps the delay before a retry when MaxBackoff is unset or 0
+const MaxRetryDelay = time.Hour
+
+// EffectiveBackoff returns the delay before the first retry, defaulting to none
+func (c *RetryConfig) EffectiveBackoff() time.Duration {
+	if c.Backoff == nil {
+		return 0
+	}
+	return *c.Backoff
+}
+
+// EffectiveMaxBackoff returns the cap on the delay, defaulting to MaxRetryDelay
+func (c *RetryConfig) EffectiveMaxBackoff() time.Duration {
+	if c.MaxBackoff == nil || *c.MaxBackoff == 0 {
+		return MaxRetryDelay
+	}
+	return *c.MaxBackoff
+}
+
+// Delay returns the delay before the given retry (1 for the first)
+func (c *RetryConfig) Delay(retry int) time.Duration {
+	limit := c.EffectiveMaxBackoff()
+	delay := min(c.EffectiveBackoff(), limit)
+	for i := 1; i < retry && delay > 0 && delay < limit; i++ {
+		// Doubling past limit could overflow
+		if delay > limit/2 {
+			delay = limit
+		} else {
+			delay *= 2
+		}
+	}
+
+	if c.Jitter != nil && *c.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + *c.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// EffectiveRetry returns the retry settings for the device: its own
+// settings on top of def
+func (d *Device) EffectiveRetry(def RetryConfig) RetryConfig {
+	return def.Merge(d.Retry)
+}
+
+// Validate checks that the retry settings are within range
+func (c *RetryConfig) Validate() error {
+	if c.Retries != nil && *c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	if c.EffectiveBackoff() < 0 || c.EffectiveMaxBackoff() < 0 {
+		return fmt.Errorf("backoff must not be negative")
+	}
+	if c.Jitter != nil && (*c.Jitter < 0 || *c.Jitter > 1) {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	return nil
+}
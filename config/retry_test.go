@@ -0,0 +1,91 @@
+package config
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+func TestRetryDelay(t *testing.T) {
+	d := func(v time.Duration) *time.Duration { return &v }
+
+	tests := []struct {
+		name  string
+		retry RetryConfig
+		want  []time.Duration
+	}{
+		{
+			name:  "doubles up to the cap",
+			retry: RetryConfig{Backoff: d(time.Second), MaxBackoff: d(5 * time.Second)},
+			want:  []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:  "zero cap",
+			retry: RetryConfig{Backoff: d(time.Minute), MaxBackoff: d(0)},
+			want:  []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, MaxRetryDelay, MaxRetryDelay},
+		},
+		{
+			name:  "no cap set",
+			retry: RetryConfig{Backoff: d(40 * time.Minute)},
+			want:  []time.Duration{40 * time.Minute, MaxRetryDelay},
+		},
+		{
+			name:  "backoff above the cap",
+			retry: RetryConfig{Backoff: d(time.Minute), MaxBackoff: d(time.Second)},
+			want:  []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:  "cap near the largest duration",
+			retry: RetryConfig{Backoff: d(time.Second), MaxBackoff: d(math.MaxInt64)},
+			want:  []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:  "no backoff",
+			retry: RetryConfig{Backoff: d(0)},
+			want:  []time.Duration{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				if got := tt.retry.Delay(i + 1); got != want {
+					t.Errorf("Delay(%d) = %s, want %s", i+1, got, want)
+				}
+			}
+		})
+	}
+
+	// Late retries stay at the cap instead of overflowing
+	huge := RetryConfig{Backoff: d(time.Second), MaxBackoff: d(math.MaxInt64)}
+	if got := huge.Delay(100); got != math.MaxInt64 {
+		t.Errorf("Delay(100) = %s, want the cap", got)
+	}
+	if got := (&RetryConfig{Backoff: d(time.Second)}).Delay(100); got != MaxRetryDelay {
+		t.Errorf("Delay(100) = %s, want %s", got, MaxRetryDelay)
+	}
+}
+
+func TestRetryMerge(t *testing.T) {
+	retries, jitter := 3, 0.2
+	backoff, maxBackoff := 5*time.Second, time.Minute
+	def := RetryConfig{Retries: &retries, Backoff: &backoff, MaxBackoff: &maxBackoff, Jitter: &jitter}
+
+	var override RetryConfig
+	if err := yaml.Unmarshal([]byte("backoff: 0s\nmax_backoff: 10s\n"), &override); err != nil {
+		t.Fatal(err)
+	}
+	got := def.Merge(override)
+
+	if got.EffectiveRetries() != 3 || *got.Jitter != 0.2 {
+		t.Errorf("retries = %d, jitter = %v, want the defaults", got.EffectiveRetries(), *got.Jitter)
+	}
+	if got.EffectiveBackoff() != 0 {
+		t.Errorf("backoff = %s, want 0 from the override", got.EffectiveBackoff())
+	}
+	if got.EffectiveMaxBackoff() != 10*time.Second {
+		t.Errorf("max backoff = %s, want 10s", got.EffectiveMaxBackoff())
+	}
+}
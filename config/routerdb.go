@@ -6,31 +6,135 @@ import (
 	"time"
 
 	"github.com/goccy/go-yaml"
+	"golang.org/x/crypto/ssh"
 )
 
+// Transports
+const (
+	TransportSSH         = "ssh"
+	TransportTelnet      = "telnet"
+	TransportNetconf     = "netconf"
+	TransportHTTPJSONRPC = "http-jsonrpc"
+	TransportRESTCONF    = "restconf"
+)
+
+// Host key policies
+const (
+	HostKeyPolicyStrict   = "strict"
+	HostKeyPolicyTOFU     = "tofu"
+	HostKeyPolicyInsecure = "insecure"
+)
+
+// Credentials represents SSH authentication settings
+type Credentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PrivateKey is the path to a private key file
+	PrivateKey string `yaml:"private_key"`
+	// Passphrase decrypts PrivateKey when it is encrypted
+	Passphrase string `yaml:"passphrase"`
+	// Certificate is the path to an OpenSSH certificate for PrivateKey
+	Certificate string `yaml:"certificate"`
+	// UseAgent enables authentication via the agent at SSH_AUTH_SOCK
+	UseAgent bool `yaml:"use_agent"`
+}
+
+// HostKeyConfig represents host key verification settings
+type HostKeyConfig struct {
+	// HostKey pins the host key in authorized_keys format
+	// (e.g. "ssh-ed25519 AAAA..."). When set, known_hosts is not consulted.
+	HostKey string `yaml:"host_key"`
+	// HostKeyPolicy overrides the global host key policy
+	HostKeyPolicy string `yaml:"host_key_policy"`
+}
+
+// JumpHost represents an SSH bastion used to reach a device
+type JumpHost struct {
+	Host          string        `yaml:"host"`
+	Port          int           `yaml:"port"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Credentials   `yaml:",inline"`
+	HostKeyConfig `yaml:",inline"`
+}
+
+// TLSConfig represents TLS settings for HTTP-based transports
+type TLSConfig struct {
+	// CA is the path to a PEM bundle used instead of the system roots
+	CA string `yaml:"ca"`
+	// Insecure skips certificate verification
+	Insecure bool `yaml:"insecure"`
+	// Disable uses plain HTTP
+	Disable bool `yaml:"disable"`
+}
+
+// Group represents settings shared by all devices in a group
+type Group struct {
+	Jump []JumpHost `yaml:"jump"`
+	// Proxy is the default proxy URL for the group's devices
+	Proxy string `yaml:"proxy"`
+}
+
 // Device represents a single device entry in routerdb.yaml
 type Device struct {
-	Name     string        `yaml:"name"`
-	IP       string        `yaml:"ip"`
-	Model    string        `yaml:"model"`
-	Group    string        `yaml:"group"`
-	Port     int           `yaml:"port"`
-	Username string        `yaml:"username"`
-	Password string        `yaml:"password"`
-	Timeout  time.Duration `yaml:"timeout"`
+	Name          string        `yaml:"name"`
+	IP            string        `yaml:"ip"`
+	Model         string        `yaml:"model"`
+	Group         string        `yaml:"group"`
+	Port          int           `yaml:"port"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Credentials   `yaml:",inline"`
+	HostKeyConfig `yaml:",inline"`
+	// Transport selects the protocol: ssh (default), telnet, or any other registered transport
+	Transport string `yaml:"transport"`
+	// EnablePassword is sent when the model's login.enable_prompt appears
+	EnablePassword string `yaml:"enable_password"`
+	// TLS configures HTTPS for HTTP-based transports
+	TLS TLSConfig `yaml:"tls"`
+	// Proxy is the URL of a SOCKS5 or HTTP CONNECT proxy to connect through,
+	// or "direct". Defaults to the group's proxy, then the -proxy flag.
+	Proxy string `yaml:"proxy"`
+	// SSH overrides the model's SSH algorithm settings
+	SSH SSHConfig `yaml:"ssh"`
+	// Jump lists the bastions to traverse, outermost first.
+	// Defaults to the jump hosts of the device's group; an empty list
+	// connects directly.
+	Jump []JumpHost `yaml:"jump"`
+	// Retry overrides the run-wide retry settings
+	Retry RetryConfig `yaml:"retry"`
 }
 
 // RouterDB represents the top-level structure of routerdb.yaml
 type RouterDB struct {
-	Devices []Device `yaml:"devices"`
+	Groups  map[string]Group `yaml:"groups"`
+	Devices []Device         `yaml:"devices"`
+}
+
+// EffectiveTransport returns the transport to use, defaulting to SSH
+func (d *Device) EffectiveTransport() string {
+	if d.Transport == "" {
+		return TransportSSH
+	}
+	return d.Transport
 }
 
-// EffectivePort returns the port to use, defaulting to 22 for SSH
+// EffectivePort returns the port to use, defaulting to the well-known port
+// of the transport (22 for SSH, 23 for Telnet, 830 for NETCONF, 443 or 80 for HTTP)
 func (d *Device) EffectivePort() int {
-	if d.Port == 0 {
-		return 22
+	if d.Port != 0 {
+		return d.Port
+	}
+	switch d.EffectiveTransport() {
+	case TransportTelnet:
+		return 23
+	case TransportNetconf:
+		return 830
+	case TransportHTTPJSONRPC, TransportRESTCONF:
+		if d.TLS.Disable {
+			return 80
+		}
+		return 443
 	}
-	return d.Port
+	return 22
 }
 
 // EffectiveTimeout returns the timeout to use, defaulting to 30 seconds
@@ -41,6 +145,31 @@ func (d *Device) EffectiveTimeout() time.Duration {
 	return d.Timeout
 }
 
+// EffectivePort returns the port to use, defaulting to 22
+func (j *JumpHost) EffectivePort() int {
+	if j.Port == 0 {
+		return 22
+	}
+	return j.Port
+}
+
+// EffectiveTimeout returns the timeout to use, defaulting to 30 seconds
+func (j *JumpHost) EffectiveTimeout() time.Duration {
+	if j.Timeout == 0 {
+		return 30 * time.Second
+	}
+	return j.Timeout
+}
+
+// ValidHostKeyPolicy reports whether policy is a known host key policy
+func ValidHostKeyPolicy(policy string) bool {
+	switch policy {
+	case HostKeyPolicyStrict, HostKeyPolicyTOFU, HostKeyPolicyInsecure:
+		return true
+	}
+	return false
+}
+
 // LoadRouterDB loads and parses routerdb.yaml from a file path
 func LoadRouterDB(path string) (*RouterDB, error) {
 	f, err := os.Open(path)
@@ -59,10 +188,32 @@ func LoadRouterDB(path string) (*RouterDB, error) {
 		return nil, err
 	}
 
+	// Devices without their own jump hosts or proxy inherit those of their
+	// group. jump: [] is decoded as an empty list rather than nil, so it
+	// opts out of the group's jump hosts.
+	for i := range db.Devices {
+		d := &db.Devices[i]
+		if d.Jump == nil {
+			d.Jump = db.Groups[d.Group].Jump
+		}
+		if d.Proxy == "" {
+			d.Proxy = db.Groups[d.Group].Proxy
+		}
+	}
+
 	return &db, nil
 }
 
 func validateRouterDB(db *RouterDB) error {
+	for name, g := range db.Groups {
+		if err := validateJumpHosts(g.Jump); err != nil {
+			return fmt.Errorf("group %q: %w", name, err)
+		}
+		if _, err := ParseProxy(g.Proxy); err != nil {
+			return fmt.Errorf("group %q: %w", name, err)
+		}
+	}
+
 	for i, d := range db.Devices {
 		if d.Name == "" {
 			return fmt.Errorf("device[%d]: name is required", i)
@@ -76,12 +227,74 @@ func validateRouterDB(db *RouterDB) error {
 		if d.Group == "" {
 			return fmt.Errorf("device[%d] (%s): group is required", i, d.Name)
 		}
-		if d.Username == "" {
-			return fmt.Errorf("device[%d] (%s): username is required", i, d.Name)
+		switch d.EffectiveTransport() {
+		case TransportTelnet, TransportHTTPJSONRPC, TransportRESTCONF:
+			if d.Password == "" {
+				return fmt.Errorf("device[%d] (%s): password is required for %s", i, d.Name, d.EffectiveTransport())
+			}
+		}
+		if err := validateCredentials(&d.Credentials); err != nil {
+			return fmt.Errorf("device[%d] (%s): %w", i, d.Name, err)
+		}
+		if err := validateHostKeyConfig(&d.HostKeyConfig); err != nil {
+			return fmt.Errorf("device[%d] (%s): %w", i, d.Name, err)
+		}
+		if err := validateJumpHosts(d.Jump); err != nil {
+			return fmt.Errorf("device[%d] (%s): %w", i, d.Name, err)
+		}
+		if err := validateSSHConfig(&d.SSH); err != nil {
+			return fmt.Errorf("device[%d] (%s): %w", i, d.Name, err)
+		}
+		if _, err := ParseProxy(d.Proxy); err != nil {
+			return fmt.Errorf("device[%d] (%s): %w", i, d.Name, err)
+		}
+		if err := d.Retry.Validate(); err != nil {
+			return fmt.Errorf("device[%d] (%s): retry: %w", i, d.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateJumpHosts(jumps []JumpHost) error {
+	for i, j := range jumps {
+		if j.Host == "" {
+			return fmt.Errorf("jump[%d]: host is required", i)
+		}
+		if err := validateCredentials(&j.Credentials); err != nil {
+			return fmt.Errorf("jump[%d] (%s): %w", i, j.Host, err)
+		}
+		if err := validateHostKeyConfig(&j.HostKeyConfig); err != nil {
+			return fmt.Errorf("jump[%d] (%s): %w", i, j.Host, err)
 		}
-		if d.Password == "" {
-			return fmt.Errorf("device[%d] (%s): password is required", i, d.Name)
+	}
+	return nil
+}
+
+func validateHostKeyConfig(h *HostKeyConfig) error {
+	if h.HostKey != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.HostKey)); err != nil {
+			return fmt.Errorf("invalid host_key: %w", err)
 		}
 	}
+	if h.HostKeyPolicy != "" && !ValidHostKeyPolicy(h.HostKeyPolicy) {
+		return fmt.Errorf("unknown host_key_policy %q", h.HostKeyPolicy)
+	}
+	return nil
+}
+
+func validateCredentials(c *Credentials) error {
+	if c.Username == "" {
+		return fmt.Errorf("username is required")
+	}
+	// Password is only required when no other authentication method is configured
+	if c.Password == "" && c.PrivateKey == "" && !c.UseAgent {
+		return fmt.Errorf("password is required")
+	}
+	if c.Certificate != "" && c.PrivateKey == "" {
+		return fmt.Errorf("certificate requires private_key")
+	}
+	if c.Passphrase != "" && c.PrivateKey == "" {
+		return fmt.Errorf("passphrase requires private_key")
+	}
 	return nil
 }
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHPresetLegacy enables, after the secure defaults, the algorithms that
+// x/crypto/ssh disables for security reasons (e.g. diffie-hellman-group1-sha1,
+// aes128-cbc, ssh-rsa), as needed by old network gear
+const SSHPresetLegacy = "legacy"
+
+// SSHConfig selects the algorithms offered in the SSH handshake. Unset lists
+// use the x/crypto/ssh defaults, or the preset when one is named.
+type SSHConfig struct {
+	Preset            string   `yaml:"preset"`
+	KeyExchanges      []string `yaml:"key_exchanges"`
+	Ciphers           []string `yaml:"ciphers"`
+	MACs              []string `yaml:"macs"`
+	HostKeyAlgorithms []string `yaml:"host_key_algorithms"`
+}
+
+// Merge returns s with the preset and every list set in override replacing its own
+func (s SSHConfig) Merge(override SSHConfig) SSHConfig {
+	if override.Preset != "" {
+		s.Preset = override.Preset
+	}
+	if override.KeyExchanges != nil {
+		s.KeyExchanges = override.KeyExchanges
+	}
+	if override.Ciphers != nil {
+		s.Ciphers = override.Ciphers
+	}
+	if override.MACs != nil {
+		s.MACs = override.MACs
+	}
+	if override.HostKeyAlgorithms != nil {
+		s.HostKeyAlgorithms = override.HostKeyAlgorithms
+	}
+	return s
+}
+
+// Resolve returns s with unset lists filled in from the preset
+func (s SSHConfig) Resolve() SSHConfig {
+	if s.Preset != SSHPresetLegacy {
+		return s
+	}
+
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	if s.KeyExchanges == nil {
+		s.KeyExchanges = slices.Concat(supported.KeyExchanges, insecure.KeyExchanges)
+	}
+	if s.Ciphers == nil {
+		s.Ciphers = slices.Concat(supported.Ciphers, insecure.Ciphers)
+	}
+	if s.MACs == nil {
+		s.MACs = slices.Concat(supported.MACs, insecure.MACs)
+	}
+	if s.HostKeyAlgorithms == nil {
+		s.HostKeyAlgorithms = slices.Concat(supported.HostKeys, insecure.HostKeys)
+	}
+	return s
+}
+
+// EffectiveSSH returns the device's SSH settings layered over the model's
+func (d *Device) EffectiveSSH(m *Model) SSHConfig {
+	return m.SSH.Merge(d.SSH)
+}
+
+func validateSSHConfig(s *SSHConfig) error {
+	if s.Preset != "" && s.Preset != SSHPresetLegacy {
+		return fmt.Errorf("ssh: unknown preset %q", s.Preset)
+	}
+
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	lists := []struct {
+		field string
+		names []string
+		known []string
+	}{
+		{"key_exchanges", s.KeyExchanges, slices.Concat(supported.KeyExchanges, insecure.KeyExchanges)},
+		{"ciphers", s.Ciphers, slices.Concat(supported.Ciphers, insecure.Ciphers)},
+		{"macs", s.MACs, slices.Concat(supported.MACs, insecure.MACs)},
+		{"host_key_algorithms", s.HostKeyAlgorithms, slices.Concat(supported.HostKeys, insecure.HostKeys)},
+	}
+	for _, l := range lists {
+		for _, name := range l.names {
+			if !slices.Contains(l.known, name) {
+				return fmt.Errorf("ssh.%s: unsupported algorithm %q (supported: %v)", l.field, name, l.known)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// maxDiffCells bounds the work of diffLines; beyond it, the differing
+// middle is shown as removed and added wholesale
+const maxDiffCells = 4 * 1024 * 1024
+
+// diffOp is a line of a diff: ' ' unchanged, '-' only in want, '+' only in got
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns a unified diff of want and got
+func diffLines(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// Common prefix and suffix
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var ops []diffOp
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ops = append(ops, diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	return formatHunks(ops)
+}
+
+// diffMiddle diffs the lines between the common prefix and suffix using
+// their longest common subsequence
+func diffMiddle(a, b []string) []diffOp {
+	var ops []diffOp
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// formatHunks renders the changes in ops with diffContext lines of context
+func formatHunks(ops []diffOp) string {
+	var sb strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are close enough to share context
+		last := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				last = k
+			} else if k-last > 2*diffContext {
+				break
+			}
+		}
+
+		from := max(first-diffContext, 0)
+		to := min(last+diffContext+1, len(ops))
+
+		// Line numbers of the hunk in want and got
+		wantLine, gotLine := 1, 1
+		for _, op := range ops[:from] {
+			if op.kind != '+' {
+				wantLine++
+			}
+			if op.kind != '-' {
+				gotLine++
+			}
+		}
+		var wantCount, gotCount int
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				wantCount++
+			}
+			if op.kind != '-' {
+				gotCount++
+			}
+		}
+
+		// An empty side is numbered by the line before it, as in diff -u
+		if wantCount == 0 {
+			wantLine--
+		}
+		if gotCount == 0 {
+			gotLine--
+		}
+
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", wantLine, wantCount, gotLine, gotCount)
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&sb, "%c%s\n", op.kind, op.line)
+		}
+		start = to
+	}
+	return sb.String()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns lines "l1" to "ln", with the lines in changed replaced
+// by "L<i>"
+func numbered(n int, changed ...int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("l%d", i+1)
+	}
+	for _, i := range changed {
+		lines[i-1] = fmt.Sprintf("L%d", i)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		want, got string
+		diff      string
+	}{
+		{
+			name: "equal",
+			want: "a\nb\n",
+			got:  "a\nb\n",
+			diff: "",
+		},
+		{
+			name: "changed line with context",
+			want: numbered(8),
+			got:  numbered(8, 4),
+			diff: "@@ -1,7 +1,7 @@\n l1\n l2\n l3\n-l4\n+L4\n l5\n l6\n l7\n",
+		},
+		{
+			name: "added at the end",
+			want: "a\nb",
+			got:  "a\nb\nc",
+			diff: "@@ -1,2 +1,3 @@\n a\n b\n+c\n",
+		},
+		{
+			name: "removed at the start",
+			want: "a\nb\nc",
+			got:  "b\nc",
+			diff: "@@ -1,3 +1,2 @@\n-a\n b\n c\n",
+		},
+		{
+			name: "removed and added around a common line",
+			want: "a\nb\nc",
+			got:  "a\nc\nd",
+			diff: "@@ -1,3 +1,3 @@\n a\n-b\n c\n+d\n",
+		},
+		{
+			name: "changes sharing context",
+			want: numbered(10),
+			got:  numbered(10, 2, 9),
+			diff: "@@ -1,10 +1,10 @@\n l1\n-l2\n+L2\n l3\n l4\n l5\n l6\n l7\n l8\n-l9\n+L9\n l10\n",
+		},
+		{
+			name: "separate hunks",
+			want: numbered(20),
+			got:  numbered(20, 2, 18),
+			diff: "@@ -1,5 +1,5 @@\n l1\n-l2\n+L2\n l3\n l4\n l5\n" +
+				"@@ -15,6 +15,6 @@\n l15\n l16\n l17\n-l18\n+L18\n l19\n l20\n",
+		},
+		{
+			name: "from empty",
+			want: "",
+			got:  "a",
+			diff: "@@ -1,1 +1,1 @@\n-\n+a\n",
+		},
+		{
+			name: "trailing newline added",
+			want: "a",
+			got:  "a\n",
+			diff: "@@ -1,1 +1,2 @@\n a\n+\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffLines(tt.want, tt.got); got != tt.diff {
+				t.Errorf("diffLines =\n%s\nwant\n%s", got, tt.diff)
+			}
+		})
+	}
+}
+
+func TestDiffMiddleLimit(t *testing.T) {
+	// Too many cells for the LCS table: all of a is removed, then all of b
+	// added
+	n := 2100
+	a := strings.Split(numbered(n), "\n")
+	b := strings.Split(numbered(n, 1), "\n")
+	if n*n <= maxDiffCells {
+		t.Fatalf("%d lines do not exceed maxDiffCells", n)
+	}
+
+	ops := diffMiddle(a, b)
+	if len(ops) != 2*n {
+		t.Fatalf("%d ops, want %d", len(ops), 2*n)
+	}
+	for i, op := range ops {
+		want := byte('-')
+		if i >= n {
+			want = '+'
+		}
+		if op.kind != want {
+			t.Fatalf("ops[%d] = %c%s, want kind %c", i, op.kind, op.line, want)
+		}
+	}
+}
+
+func TestFormatHunks(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  []diffOp
+		want string
+	}{
+		{
+			name: "no changes",
+			ops:  []diffOp{{' ', "a"}, {' ', "b"}},
+			want: "",
+		},
+		{
+			name: "context trimmed to diffContext",
+			ops: []diffOp{
+				{' ', "1"}, {' ', "2"}, {' ', "3"}, {' ', "4"}, {' ', "5"},
+				{'+', "new"},
+				{' ', "6"}, {' ', "7"}, {' ', "8"}, {' ', "9"},
+			},
+			want: "@@ -3,6 +3,7 @@\n 3\n 4\n 5\n+new\n 6\n 7\n 8\n",
+		},
+		{
+			name: "only removed",
+			ops:  []diffOp{{'-', "a"}, {'-', "b"}},
+			want: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatHunks(tt.ops); got != tt.want {
+				t.Errorf("formatHunks =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zinrai/netback/transport"
+)
+
+// Phases reported by CommandError
+const (
+	PhaseComment = "comment"
+	PhaseCommand = "command"
+	PhaseFile    = "file"
+)
+
+// commandPhase returns PhaseComment for comments and PhaseCommand otherwise
+func commandPhase(comment bool) string {
+	if comment {
+		return PhaseComment
+	}
+	return PhaseCommand
+}
+
+// CommandError is returned when a command or file retrieval fails,
+// including when the output matches one of the model's errors patterns
+type CommandError struct {
+	// Phase is PhaseComment, PhaseCommand or PhaseFile
+	Phase string
+	// Command is the command line, or the path for PhaseFile
+	Command string
+	// Line is the output line that matched an errors pattern, if that is
+	// why the command failed
+	Line string
+	Err  error
+}
+
+func (e *CommandError) Error() string {
+	switch e.Phase {
+	case PhaseComment:
+		return fmt.Sprintf("execute comment %q: %v", e.Command, e.Err)
+	case PhaseFile:
+		return fmt.Sprintf("retrieve file %q: %v", e.Command, e.Err)
+	default:
+		return fmt.Sprintf("execute %q: %v", e.Command, e.Err)
+	}
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// WriteError is returned when the backup could not be saved
+type WriteError struct {
+	// Path is the output file
+	Path string
+	Err  error
+}
+
+func (e *WriteError) Error() string { return "save backup: " + e.Err.Error() }
+
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// Failure classes returned by Classify, in the order they are checked
+const (
+	ClassCancelled = "cancelled"
+	ClassHostKey   = "host key"
+	ClassAuth      = "auth"
+	ClassDial      = "dial"
+	ClassTimeout   = "timeout"
+	ClassCommand   = "command"
+	ClassWrite     = "write"
+	ClassOther     = "other"
+)
+
+// Classes lists the failure classes in the order they are checked
+var Classes = []string{
+	ClassCancelled, ClassHostKey, ClassAuth, ClassDial,
+	ClassTimeout, ClassCommand, ClassWrite, ClassOther,
+}
+
+// Classify returns the class of a backup failure. An error matching
+// several classes gets the first in Classes, so that e.g. a command that
+// timed out is a timeout and a proxy rejecting the credentials is an
+// authentication failure.
+func Classify(err error) string {
+	var (
+		hostKeyErr *transport.HostKeyError
+		authErr    *transport.AuthError
+		dialErr    *transport.DialError
+		timeoutErr *transport.PromptTimeout
+		cmdErr     *CommandError
+		writeErr   *WriteError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ClassCancelled
+	case errors.As(err, &hostKeyErr):
+		return ClassHostKey
+	case errors.As(err, &authErr):
+		return ClassAuth
+	case errors.As(err, &dialErr):
+		return ClassDial
+	case errors.As(err, &timeoutErr), errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.As(err, &cmdErr):
+		return ClassCommand
+	case errors.As(err, &writeErr):
+		return ClassWrite
+	}
+	return ClassOther
+}
+
+// FailurePhase returns the phase of the session a backup failed in, as
+// recorded by the typed errors in err's chain, or "" if none records it
+func FailurePhase(err error) string {
+	var (
+		cmdErr     *CommandError
+		hostKeyErr *transport.HostKeyError
+		authErr    *transport.AuthError
+		dialErr    *transport.DialError
+		timeoutErr *transport.PromptTimeout
+	)
+	switch {
+	case errors.As(err, &cmdErr):
+		return cmdErr.Phase
+	case errors.As(err, &hostKeyErr):
+		return hostKeyErr.Phase
+	case errors.As(err, &authErr):
+		return authErr.Phase
+	case errors.As(err, &dialErr):
+		return dialErr.Phase
+	case errors.As(err, &timeoutErr):
+		return timeoutErr.Phase
+	}
+	return ""
+}
@@ -1,67 +1,311 @@
 package executor
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/zinrai/netback/config"
 	"github.com/zinrai/netback/transport"
 )
 
+// CommandResult represents the outcome of a single command
+type CommandResult struct {
+	Command string
+	// Comment is true for commands from the model's comments list
+	Comment bool
+	// RawOutput is the output as returned by the transport, before
+	// normalization and secrets masking. It may contain secrets and is
+	// never written to the backup.
+	RawOutput string
+	// Output is RawOutput normalized and with secrets masked
+	Output   string
+	Duration time.Duration
+	Error    error
+	// ErrorLine is the output line that matched one of the model's errors
+	// patterns, if any
+	ErrorLine string
+	// Skipped is true when the command failed or reported an error and its
+	// output was left out
+	Skipped bool
+}
+
+// FileResult represents a file retrieved from the device
+type FileResult struct {
+	Path string
+	// Content is the file content after secrets masking
+	Content  string
+	Duration time.Duration
+	Error    error
+}
+
 // Result represents the result of backing up a device
 type Result struct {
-	Device *config.Device
-	Output string
-	Error  error
+	Device   *config.Device
+	Commands []CommandResult
+	Files    []FileResult
+	Output   string
+	Error    error
+	// Attempts is the number of times the backup was tried
+	Attempts int
+	// Start and End bracket all attempts
+	Start time.Time
+	End   time.Time
+	// BytesWritten and Changed describe the saved backup, including
+	// separately stored files; they are set by the caller saving it
+	BytesWritten int
+	Changed      bool
+	// Transcript is the end of what the device sent during the CLI session
+	// of the last attempt, normalized and with secrets masked
+	Transcript string
+}
+
+// Execute connects to a device and collects the configuration.
+// Cancelling ctx aborts the backup, leaving the error in the result.
+// Messages are logged to opts.Logger (or slog.Default()) with the device's
+// attributes.
+func Execute(ctx context.Context, device *config.Device, model *config.Model, opts *transport.Options) *Result {
+	return execute(ctx, device, model, opts, DeviceLogger(opts.Logger, device))
 }
 
-// Execute connects to a device and collects the configuration
-func Execute(device *config.Device, model *config.Model) *Result {
-	result := &Result{Device: device}
+// DeviceLogger returns logger, or slog.Default() if nil, with the device,
+// group and model attributes of device
+func DeviceLogger(logger *slog.Logger, device *config.Device) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("device", device.Name, "group", device.Group, "model", device.Model)
+}
 
-	commentsOutputs, commandsOutputs, err := transport.ConnectAndExecute(device, model)
+// execute runs Execute, logging to logger
+func execute(ctx context.Context, device *config.Device, model *config.Model, opts *transport.Options, logger *slog.Logger) *Result {
+	// Keep the end of the session for the result, without the device's
+	// passwords, in the transcript too
+	tail := newTailWriter(transcriptTail)
+	deviceOpts := *opts
+	deviceOpts.Recorder = opts.Recorder.Tee(tail)
+	deviceOpts.Recorder.Redact(device.Password, device.EnablePassword)
+	deviceOpts.Logger = logger
+
+	t, err := transport.New(device, model, &deviceOpts)
 	if err != nil {
+		now := time.Now()
+		return &Result{Device: device, Error: err, Start: now, End: now}
+	}
+
+	result := ExecuteTransport(ctx, t, device, model, logger)
+	result.Transcript = cleanTranscript(tail.String(), model)
+	return result
+}
+
+// ExecuteTransport connects t, collects the configuration and closes it.
+// Execute uses it with the transport created for the device; it also runs
+// a backup over a transport created otherwise, such as a transport.Replay.
+func ExecuteTransport(ctx context.Context, t transport.Transport, device *config.Device, model *config.Model, logger *slog.Logger) *Result {
+	result := &Result{Device: device, Start: time.Now()}
+	defer func() { result.End = time.Now() }()
+
+	if err := t.Connect(ctx); err != nil {
 		result.Error = err
 		return result
 	}
+	defer t.Close()
 
-	var outputParts []string
-
-	// Process comments outputs (all lines commented for each command)
-	for _, output := range commentsOutputs {
-		processed, err := processOutput(output, model)
-		if err != nil {
-			result.Error = err
+	// Execute comment commands (each output stored separately)
+	logger.Debug("executing comments", "phase", PhaseComment)
+	for i := range model.Comments {
+		cmd := &model.Comments[i]
+		cr := run(ctx, t, logger, model, cmd, true)
+		failed := cr.Error != nil && !skipOptional(ctx, logger, cmd, &cr)
+		result.Commands = append(result.Commands, cr)
+		if failed {
+			result.Error = &CommandError{Phase: PhaseComment, Command: cmd.Cmd, Line: cr.ErrorLine, Err: cr.Error}
 			return result
 		}
-		processed = commentAllLines(processed, model.Comment)
-		if processed != "" {
-			outputParts = append(outputParts, processed)
+	}
+
+	// Execute config commands (each output stored separately)
+	logger.Debug("executing commands", "phase", PhaseCommand)
+	for i := range model.Commands {
+		cmd := &model.Commands[i]
+		cr := run(ctx, t, logger, model, cmd, false)
+		failed := cr.Error != nil && !skipOptional(ctx, logger, cmd, &cr)
+		result.Commands = append(result.Commands, cr)
+		if failed {
+			result.Error = &CommandError{Phase: PhaseCommand, Command: cmd.Cmd, Line: cr.ErrorLine, Err: cr.Error}
+			return result
 		}
 	}
 
-	// Process commands outputs (first and last lines commented for each command)
-	for _, output := range commandsOutputs {
-		processed, err := processOutput(output, model)
-		if err != nil {
-			result.Error = err
+	// Retrieve files
+	if len(model.Files) > 0 {
+		logger.Debug("retrieving files", "phase", PhaseFile)
+		fetcher, ok := t.(transport.FileFetcher)
+		if !ok {
+			result.Error = fmt.Errorf("transport %q does not support file retrieval", device.EffectiveTransport())
 			return result
 		}
-		processed = commentFirstLastLines(processed, model.Comment)
+		for _, path := range model.Files {
+			fr := fetch(ctx, fetcher, path)
+			if fr.Error != nil {
+				result.Files = append(result.Files, fr)
+				result.Error = &CommandError{Phase: PhaseFile, Command: path, Err: fr.Error}
+				return result
+			}
+			content, err := processOutput(fr.Content, model, nil)
+			if err != nil {
+				result.Error = err
+				return result
+			}
+			fr.Content = content
+			result.Files = append(result.Files, fr)
+		}
+	}
+
+	// Transports without CLI framing return output without echo and prompt
+	framed := true
+	if f, ok := t.(transport.Framer); ok {
+		framed = f.Framed()
+	}
+
+	var outputParts []string
+
+	for _, cr := range result.Commands {
+		if cr.Skipped {
+			continue
+		}
+
+		processed := cr.Output
+		switch {
+		case !framed && cr.Comment:
+			// Output has no command echo; add the command as a header line
+			processed = commentAllLines(addHeader(processed, cr.Command), model.Comment)
+		case !framed:
+			processed = addCommentedHeader(processed, cr.Command, model.Comment)
+		case cr.Comment:
+			// All lines commented for comments output
+			processed = commentAllLines(processed, model.Comment)
+		default:
+			// First and last lines commented for commands output
+			processed = commentFirstLastLines(processed, model.Comment)
+		}
+
 		if processed != "" {
 			outputParts = append(outputParts, processed)
 		}
 	}
 
+	// Appended files get a commented header naming the path
+	if model.EffectiveFileOutput() == config.FileOutputAppend {
+		for _, fr := range result.Files {
+			outputParts = append(outputParts, addCommentedHeader(fr.Content, fr.Path, model.Comment))
+		}
+	}
+
 	result.Output = strings.Join(outputParts, "\n")
 
 	return result
 }
 
-// processOutput applies all filtering rules to the raw output
-func processOutput(rawOutput string, model *config.Model) (string, error) {
+// run executes a single command, processes its output and checks it for
+// device-side errors, recording the outcome
+func run(ctx context.Context, t transport.Transport, logger *slog.Logger, model *config.Model, cmd *config.Command, comment bool) CommandResult {
+	start := time.Now()
+	output, err := t.Run(ctx, cmd)
+	cr := CommandResult{
+		Command:   cmd.Cmd,
+		Comment:   comment,
+		RawOutput: output,
+		Duration:  time.Since(start),
+		Error:     err,
+	}
+	if err != nil {
+		return cr
+	}
+
+	cr.Output, cr.Error = processOutput(output, model, cmd)
+	if cr.Error != nil {
+		return cr
+	}
+
+	cr.ErrorLine, cr.Error = findError(cr.Output, model)
+	if cr.Error != nil || cr.ErrorLine == "" {
+		return cr
+	}
+	switch cmd.EffectiveOnError() {
+	case config.OnErrorWarn:
+		logger.Warn("command reported an error, keeping output", "phase", commandPhase(comment), "command", cmd.Cmd, "line", cr.ErrorLine)
+	case config.OnErrorSkip:
+		logger.Warn("command reported an error, skipping", "phase", commandPhase(comment), "command", cmd.Cmd, "line", cr.ErrorLine)
+		cr.Skipped = true
+	default:
+		cr.Error = fmt.Errorf("device reported an error: %s", cr.ErrorLine)
+	}
+	return cr
+}
+
+// findError returns the first output line matching one of the model's
+// errors patterns, or "" if none does
+func findError(output string, model *config.Model) (string, error) {
+	regexes, err := model.ErrorRegexes()
+	if err != nil {
+		return "", err
+	}
+
+	for _, re := range regexes {
+		loc := re.FindStringIndex(output)
+		if loc == nil {
+			continue
+		}
+		start := strings.LastIndexByte(output[:loc[0]], '\n') + 1
+		end := len(output)
+		if i := strings.IndexByte(output[loc[1]:], '\n'); i >= 0 {
+			end = loc[1] + i
+		}
+		return strings.TrimSpace(output[start:end]), nil
+	}
+	return "", nil
+}
+
+// skipOptional marks a failed optional command as skipped and reports
+// whether it was. Failures caused by cancelling the backup, or that lost
+// the session, are never skipped.
+func skipOptional(ctx context.Context, logger *slog.Logger, cmd *config.Command, cr *CommandResult) bool {
+	var lost *transport.SessionLost
+	if !cmd.Optional || ctx.Err() != nil || errors.As(cr.Error, &lost) {
+		return false
+	}
+	logger.Warn("optional command failed, skipping", "phase", commandPhase(cr.Comment), "command", cr.Command, "error", cr.Error)
+	cr.Skipped = true
+	return true
+}
+
+// fetch retrieves a single file and records its outcome
+func fetch(ctx context.Context, f transport.FileFetcher, path string) FileResult {
+	start := time.Now()
+	data, err := f.FetchFile(ctx, path)
+	return FileResult{
+		Path:     path,
+		Content:  string(data),
+		Duration: time.Since(start),
+		Error:    err,
+	}
+}
+
+// processOutput applies all filtering rules to the raw output of cmd, or
+// of a retrieved file if cmd is nil. Command output is first normalized if
+// the model asks for it.
+func processOutput(rawOutput string, model *config.Model, cmd *config.Command) (string, error) {
 	output := rawOutput
 
+	// Clean up escape sequences, overwrites and line endings
+	if cmd != nil && model.Terminal.Normalize {
+		output = normalizeTerminal(output)
+	}
+
 	// Apply secrets masking
 	output, err := applySecrets(output, model.Secrets)
 	if err != nil {
@@ -69,6 +313,12 @@ func processOutput(rawOutput string, model *config.Model) (string, error) {
 	}
 
 	// Apply expect replacements (for any remaining patterns)
+	if cmd != nil {
+		output, err = applyExpectReplacements(output, cmd.Expect)
+		if err != nil {
+			return "", err
+		}
+	}
 	output, err = applyExpectReplacements(output, model.Expect)
 	if err != nil {
 		return "", err
@@ -80,6 +330,18 @@ func processOutput(rawOutput string, model *config.Model) (string, error) {
 // applySecrets masks sensitive information in the output
 func applySecrets(output string, secrets []config.FilterRule) (string, error) {
 	for _, secret := range secrets {
+		if secret.JSONPath != "" {
+			path, err := secret.Path()
+			if err != nil {
+				return "", fmt.Errorf("secret json_path: %w", err)
+			}
+			output, err = applyJSONPath(output, path, secret.Replace)
+			if err != nil {
+				return "", fmt.Errorf("secret json_path %q: %w", secret.JSONPath, err)
+			}
+			continue
+		}
+
 		re, err := secret.Regex()
 		if err != nil {
 			return "", fmt.Errorf("secret pattern: %w", err)
@@ -91,7 +353,8 @@ func applySecrets(output string, secrets []config.FilterRule) (string, error) {
 
 // applyExpectReplacements applies any replace rules from expect patterns
 func applyExpectReplacements(output string, expects []config.ExpectRule) (string, error) {
-	for _, expect := range expects {
+	for i := range expects {
+		expect := &expects[i]
 		if expect.Replace == "" && expect.Send != "" {
 			// This is a send-only rule, skip replacement
 			continue
@@ -119,6 +382,22 @@ func commentAllLines(output string, prefix string) string {
 	return strings.Join(lines, "\n")
 }
 
+// addHeader prepends the command as the first line of the output
+func addHeader(output, cmd string) string {
+	if output == "" {
+		return cmd
+	}
+	return cmd + "\n" + output
+}
+
+// addCommentedHeader prepends the command as a commented line, leaving the output untouched
+func addCommentedHeader(output, cmd, prefix string) string {
+	if prefix == "" {
+		return output
+	}
+	return addHeader(output, prefix+cmd)
+}
+
 // commentFirstLastLines comments only the first and last non-empty lines
 func commentFirstLastLines(output string, prefix string) string {
 	if output == "" || prefix == "" {
@@ -0,0 +1,251 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/transport"
+)
+
+// fakeTransport answers each command from outputs, or fails it with errs
+type fakeTransport struct {
+	outputs map[string]string
+	errs    map[string]error
+	ran     []string
+}
+
+func (f *fakeTransport) Connect(context.Context) error { return nil }
+
+func (f *fakeTransport) Run(_ context.Context, cmd *config.Command) (string, error) {
+	f.ran = append(f.ran, cmd.Cmd)
+	return f.outputs[cmd.Cmd], f.errs[cmd.Cmd]
+}
+
+func (f *fakeTransport) Close() error { return nil }
+
+func TestExecuteOptionalCommandFailure(t *testing.T) {
+	timeout := &transport.PromptTimeout{Phase: transport.PhaseCommand, Command: "show tech-support", Err: context.DeadlineExceeded}
+
+	tests := []struct {
+		name    string
+		err     error
+		skipped bool
+		ran     []string
+	}{
+		{
+			name:    "timeout",
+			err:     timeout,
+			skipped: true,
+			ran:     []string{"show tech-support", "show running-config"},
+		},
+		{
+			name: "session lost",
+			err:  &transport.SessionLost{Err: timeout},
+			ran:  []string{"show tech-support"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &config.Model{Commands: []config.Command{
+				{Cmd: "show tech-support", Optional: true},
+				{Cmd: "show running-config"},
+			}}
+			ft := &fakeTransport{
+				outputs: map[string]string{"show running-config": "hostname r1\n"},
+				errs:    map[string]error{"show tech-support": tt.err},
+			}
+			logger := slog.New(slog.DiscardHandler)
+
+			result := ExecuteTransport(context.Background(), ft, &config.Device{Name: "r1"}, model, logger)
+			if got := result.Error == nil; got != tt.skipped {
+				t.Errorf("result error = %v, want skipped %v", result.Error, tt.skipped)
+			}
+			if !tt.skipped && !errors.As(result.Error, new(*transport.SessionLost)) {
+				t.Errorf("result error = %v, want a SessionLost", result.Error)
+			}
+			if got := result.Commands[0].Skipped; got != tt.skipped {
+				t.Errorf("Skipped = %v, want %v", got, tt.skipped)
+			}
+			if !slices.Equal(ft.ran, tt.ran) {
+				t.Errorf("ran %q, want %q", ft.ran, tt.ran)
+			}
+		})
+	}
+}
+
+func TestExecuteRestconfJSONPathSecrets(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+		// err is set when the output cannot be masked
+		err bool
+	}{
+		{
+			name: "json",
+			body: `{"native":{"username":[{"name":"admin","password":"s3cret"}]}}`,
+			want: `{"native":{"username":[{"name":"admin","password":"<removed>"}]}}`,
+		},
+		{
+			name: "not json",
+			body: `<native><username><name>admin</name><password>s3cret</password></username></native>`,
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/.well-known/host-meta" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(tt.body))
+			}))
+			t.Cleanup(srv.Close)
+
+			host, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			device := &config.Device{Name: "r1", IP: host, Transport: config.TransportRESTCONF}
+			device.Port, _ = strconv.Atoi(port)
+			device.TLS.Disable = true
+			model := &config.Model{
+				Secrets:  []config.FilterRule{{JSONPath: "$..password", Replace: "<removed>"}},
+				Commands: []config.Command{{Cmd: "native"}},
+			}
+			logger := slog.New(slog.DiscardHandler)
+			rc := transport.NewRestconfClient(device, model, &transport.Options{Dialer: transport.NewDialer(nil, nil), Logger: logger})
+
+			result := ExecuteTransport(context.Background(), rc, device, model, logger)
+			if strings.Contains(result.Output, "s3cret") || strings.Contains(result.Commands[0].Output, "s3cret") {
+				t.Errorf("secret left in the output %q", result.Commands[0].Output)
+			}
+			if tt.err {
+				if result.Error == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if result.Error != nil {
+				t.Fatal(result.Error)
+			}
+			if result.Commands[0].Output != tt.want {
+				t.Errorf("output = %q, want %q", result.Commands[0].Output, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteErrorPolicies(t *testing.T) {
+	const errorLine = "% Invalid input detected at '^' marker."
+
+	tests := []struct {
+		policy string
+		// fail is set when the device fails on the error
+		fail    bool
+		skipped bool
+		// kept is set when the command's output is in the backup
+		kept bool
+		// logged is the message logged about the error
+		logged string
+	}{
+		{policy: config.OnErrorFail, fail: true},
+		{policy: config.OnErrorWarn, kept: true, logged: "command reported an error, keeping output"},
+		{policy: config.OnErrorSkip, skipped: true, logged: "command reported an error, skipping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			model := &config.Model{
+				Errors: []string{`^% Invalid input`},
+				Commands: []config.Command{
+					{Cmd: "show archive", OnError: tt.policy},
+					{Cmd: "show running-config"},
+				},
+			}
+			ft := &fakeTransport{outputs: map[string]string{
+				"show archive":        "archive log\n" + errorLine + "\n",
+				"show running-config": "hostname r1\n",
+			}}
+			var logs strings.Builder
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+			result := ExecuteTransport(context.Background(), ft, &config.Device{Name: "r1"}, model, logger)
+
+			cr := result.Commands[0]
+			if cr.ErrorLine != errorLine {
+				t.Errorf("ErrorLine = %q, want %q", cr.ErrorLine, errorLine)
+			}
+			if cr.Skipped != tt.skipped {
+				t.Errorf("Skipped = %v, want %v", cr.Skipped, tt.skipped)
+			}
+
+			if tt.fail {
+				var cmdErr *CommandError
+				if !errors.As(result.Error, &cmdErr) {
+					t.Fatalf("result error = %v, want a CommandError", result.Error)
+				}
+				if cmdErr.Command != "show archive" || cmdErr.Line != errorLine {
+					t.Errorf("CommandError = %+v", cmdErr)
+				}
+				if !slices.Equal(ft.ran, []string{"show archive"}) {
+					t.Errorf("ran %q, want the failed command only", ft.ran)
+				}
+				return
+			}
+
+			if result.Error != nil {
+				t.Fatalf("result error = %v", result.Error)
+			}
+			if got := strings.Contains(result.Output, "archive log"); got != tt.kept {
+				t.Errorf("output kept = %v, want %v:\n%s", got, tt.kept, result.Output)
+			}
+			if !strings.Contains(result.Output, "hostname r1") {
+				t.Errorf("output of the next command missing:\n%s", result.Output)
+			}
+			if !strings.Contains(logs.String(), `msg="`+tt.logged+`"`) ||
+				!strings.Contains(logs.String(), `line="`+errorLine+`"`) {
+				t.Errorf("log = %s, want %q with the line", logs.String(), tt.logged)
+			}
+		})
+	}
+}
+
+func TestFindError(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors []string
+		output string
+		want   string
+	}{
+		{name: "no match", errors: []string{`^% Invalid`}, output: "hostname r1\n"},
+		{name: "whole line", errors: []string{`(?m)^% Invalid`}, output: "a\n% Invalid input\nb\n", want: "% Invalid input"},
+		{name: "match mid-line", errors: []string{`not found`}, output: "a\n  error: file not found  \nb\n", want: "error: file not found"},
+		{name: "last line", errors: []string{`denied`}, output: "a\npermission denied", want: "permission denied"},
+		{name: "first pattern wins", errors: []string{`second`, `first`}, output: "first\nsecond\n", want: "second"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findError(tt.output, &config.Model{Errors: tt.errors})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
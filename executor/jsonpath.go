@@ -0,0 +1,199 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/zinrai/netback/config"
+)
+
+// jsonValue is a decoded JSON value that remembers where it is in the
+// document, so that masking replaces values in place and leaves the
+// layout of the output as the device sent it
+type jsonValue struct {
+	members []jsonMember // objects
+	items   []*jsonValue // arrays
+	isObj   bool
+	isArr   bool
+	// start and end are the offsets of the value in the document
+	start int
+	end   int
+}
+
+type jsonMember struct {
+	key   string
+	value *jsonValue
+}
+
+// applyJSONPath replaces the values selected by path with replace.
+// Output that is not a JSON document fails, as its secrets could not be
+// masked; empty output, or output with no value selected, is returned
+// unchanged.
+func applyJSONPath(output string, path config.JSONPath, replace string) (string, error) {
+	root, err := parseJSON(output)
+	if err != nil {
+		if strings.TrimSpace(output) == "" {
+			return output, nil
+		}
+		return "", fmt.Errorf("output is not JSON: %w", err)
+	}
+
+	var matches []*jsonValue
+	matchJSON(root, path, &matches)
+	if len(matches) == 0 {
+		return output, nil
+	}
+
+	repl, err := marshalScalar(replace)
+	if err != nil {
+		return "", err
+	}
+
+	slices.SortFunc(matches, func(a, b *jsonValue) int { return a.start - b.start })
+	var sb strings.Builder
+	pos := 0
+	for _, m := range matches {
+		if m.start < pos {
+			// Inside a value already replaced, or matched twice
+			continue
+		}
+		sb.WriteString(output[pos:m.start])
+		sb.Write(repl)
+		pos = m.end
+	}
+	sb.WriteString(output[pos:])
+	return sb.String(), nil
+}
+
+// matchJSON walks v along path, collecting every match
+func matchJSON(v *jsonValue, path config.JSONPath, matches *[]*jsonValue) {
+	if len(path) == 0 {
+		return
+	}
+	seg := path[0]
+
+	visit := func(key string, index int, child *jsonValue) {
+		if segmentMatches(seg, key, index) {
+			if len(path) == 1 {
+				*matches = append(*matches, child)
+			} else {
+				matchJSON(child, path[1:], matches)
+			}
+		}
+		if seg.Recursive {
+			matchJSON(child, path, matches)
+		}
+	}
+
+	switch {
+	case v.isObj:
+		for _, m := range v.members {
+			visit(m.key, -1, m.value)
+		}
+	case v.isArr:
+		for i, item := range v.items {
+			visit("", i, item)
+		}
+	}
+}
+
+// segmentMatches reports whether an object member (index < 0) or array element matches seg
+func segmentMatches(seg config.JSONPathSegment, key string, index int) bool {
+	switch {
+	case seg.Wildcard:
+		return true
+	case seg.IsIndex:
+		return index == seg.Index
+	default:
+		return index < 0 && key == seg.Key
+	}
+}
+
+// parseJSON decodes a single JSON document
+func parseJSON(data string) (*jsonValue, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+
+	v, err := decodeJSON(dec, data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after json document")
+	}
+	return v, nil
+}
+
+// decodeJSON decodes the next value of data from dec
+func decodeJSON(dec *json.Decoder, data string) (*jsonValue, error) {
+	// The value starts after the whitespace and separators that follow
+	// the previous token
+	start := int(dec.InputOffset())
+	for start < len(data) && strings.IndexByte(" \t\r\n:,", data[start]) >= 0 {
+		start++
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &jsonValue{start: start}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		v.end = int(dec.InputOffset())
+		return v, nil
+	}
+
+	switch delim {
+	case '{':
+		v.isObj = true
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyTok.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object key %v", keyTok)
+			}
+			child, err := decodeJSON(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			v.members = append(v.members, jsonMember{key: key, value: child})
+		}
+	case '[':
+		v.isArr = true
+		for dec.More() {
+			child, err := decodeJSON(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			v.items = append(v.items, child)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected delimiter %v", delim)
+	}
+
+	// Consume the closing delimiter
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	v.end = int(dec.InputOffset())
+	return v, nil
+}
+
+// marshalScalar encodes a scalar without escaping HTML characters
+func marshalScalar(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("encode json: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,131 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/zinrai/netback/config"
+)
+
+func TestApplyJSONPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		input  string
+		want   string
+		masked string
+		// err is set when the output cannot be masked
+		err bool
+	}{
+		{
+			name:  "key",
+			path:  "$.password",
+			input: `{"user":"admin","password":"s3cret"}`,
+			want:  `{"user":"admin","password":"<masked>"}`,
+		},
+		{
+			name:  "recursive",
+			path:  "$..password",
+			input: `{"a":{"password":"x"},"b":[{"password":1},{"password":null}],"password":true}`,
+			want:  `{"a":{"password":"<masked>"},"b":[{"password":"<masked>"},{"password":"<masked>"}],"password":"<masked>"}`,
+		},
+		{
+			name:  "wildcard member",
+			path:  "$.keys.*",
+			input: `{"keys":{"a":"1","b":{"c":"2"}},"other":"3"}`,
+			want:  `{"keys":{"a":"<masked>","b":"<masked>"},"other":"3"}`,
+		},
+		{
+			name:  "wildcard element",
+			path:  "$.users[*].secret",
+			input: `{"users":[{"name":"a","secret":"x"},{"name":"b"},{"name":"c","secret":"y"}]}`,
+			want:  `{"users":[{"name":"a","secret":"<masked>"},{"name":"b"},{"name":"c","secret":"<masked>"}]}`,
+		},
+		{
+			name:  "index",
+			path:  "$.users[1]",
+			input: `{"users":["a","b","c"]}`,
+			want:  `{"users":["a","<masked>","c"]}`,
+		},
+		{
+			name:  "bracket key",
+			path:  "$['auth-key']",
+			input: `{"auth-key":"k","key":"v"}`,
+			want:  `{"auth-key":"<masked>","key":"v"}`,
+		},
+		{
+			name:  "nested match inside a replaced value",
+			path:  "$..secret",
+			input: `{"secret":{"secret":"inner"},"x":1}`,
+			want:  `{"secret":"<masked>","x":1}`,
+		},
+		{
+			name:  "four-space indentation kept",
+			path:  "$..password",
+			input: "{\n    \"user\": \"admin\",\n    \"password\" :  \"s3cret\",\n    \"list\": [ 1, 2 ]\n}\n",
+			want:  "{\n    \"user\": \"admin\",\n    \"password\" :  \"<masked>\",\n    \"list\": [ 1, 2 ]\n}\n",
+		},
+		{
+			name:  "no match leaves the document untouched",
+			path:  "$..password",
+			input: "{\n    \"user\":   \"admin\",\n    \"n\": 1.50\n}",
+			want:  "{\n    \"user\":   \"admin\",\n    \"n\": 1.50\n}",
+		},
+		{
+			name:  "escaped strings kept",
+			path:  "$.b",
+			input: `{"a":"é\"<>","b":"x\ny"}`,
+			want:  `{"a":"é\"<>","b":"<masked>"}`,
+		},
+		{
+			name:   "replacement escaped",
+			path:   "$.b",
+			input:  `{"b":"x"}`,
+			masked: `say "<hi>"`,
+			want:   `{"b":"say \"<hi>\""}`,
+		},
+		{
+			name:  "empty",
+			path:  "$.password",
+			input: "\n",
+			want:  "\n",
+		},
+		{
+			name:  "not json",
+			path:  "$.password",
+			input: "password s3cret\n",
+			err:   true,
+		},
+		{
+			name:  "trailing data",
+			path:  "$.password",
+			input: `{"password":"x"} {}`,
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := config.ParseJSONPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			masked := tt.masked
+			if masked == "" {
+				masked = "<masked>"
+			}
+			got, err := applyJSONPath(tt.input, path, masked)
+			if tt.err {
+				if err == nil {
+					t.Errorf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/transport"
+)
+
+// ExecuteWithRetry runs Execute, retrying failures that are likely to be
+// transient as configured by retry. The result records the number of
+// attempts made, and log messages carry the attempt.
+func ExecuteWithRetry(ctx context.Context, device *config.Device, model *config.Model, opts *transport.Options, retry config.RetryConfig) *Result {
+	logger := DeviceLogger(opts.Logger, device)
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		attemptLogger := logger.With("attempt", attempt)
+		opts.Recorder.Note("attempt %d", attempt)
+		result := execute(ctx, device, model, opts, attemptLogger)
+		result.Attempts = attempt
+		result.Start = start
+
+		if result.Error == nil || attempt > retry.EffectiveRetries() || !retryable(ctx, result.Error) {
+			return result
+		}
+
+		delay := retry.Delay(attempt)
+		attemptLogger.Warn("attempt failed, retrying", "delay", delay.Round(time.Millisecond), "error_class", Classify(result.Error), "error", result.Error)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			result.Error = fmt.Errorf("%w; last attempt: %v", ctx.Err(), result.Error)
+			result.End = time.Now()
+			return result
+		}
+	}
+}
+
+// retryable reports whether err is worth retrying: dial failures, timeouts
+// and connections closed or reset by the peer. Anything else, such as an
+// authentication failure or an error reported by the device, would fail
+// the same way again.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	switch Classify(err) {
+	case ClassHostKey, ClassAuth:
+		return false
+	case ClassDial, ClassTimeout:
+		return true
+	}
+	switch {
+	case errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EHOSTUNREACH),
+		errors.Is(err, syscall.ENETUNREACH),
+		errors.Is(err, syscall.EPIPE):
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
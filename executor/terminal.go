@@ -0,0 +1,156 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxColumn bounds cursor movement, so that a bogus column in an escape
+// sequence cannot pad a line with millions of blanks
+const maxColumn = 16 * 1024
+
+// normalizeTerminal renders output the way a terminal would display it.
+// Escape sequences are removed, except that erase-in-line and horizontal
+// cursor movement are applied. Backspaces and carriage returns move the
+// cursor so that later text overwrites earlier text, and every line ends
+// with \n. Trailing blanks left on overwritten lines (e.g. an erased pager
+// prompt) are trimmed. Bytes that are not valid UTF-8 are kept as they
+// are, taking a column each.
+func normalizeTerminal(output string) string {
+	var out strings.Builder
+	out.Grow(len(output))
+
+	// line holds the text of each column: a character, or an invalid byte
+	var line []string
+	col := 0
+	rewritten := false
+
+	put := func(cell string) {
+		if col < len(line) {
+			line[col] = cell
+			rewritten = true
+		} else {
+			for len(line) < col {
+				line = append(line, " ")
+			}
+			line = append(line, cell)
+		}
+		col++
+	}
+	flush := func() {
+		text := strings.Join(line, "")
+		if rewritten {
+			text = strings.TrimRight(text, " ")
+		}
+		out.WriteString(text)
+		line, col, rewritten = line[:0], 0, false
+	}
+
+	for i := 0; i < len(output); {
+		r, size := utf8.DecodeRuneInString(output[i:])
+		switch {
+		case r == '\x1b':
+			n, final, params := parseEscape(output[i:])
+			switch final {
+			case 'K':
+				// Erase in line: to end (0), to cursor (1) or whole line (2)
+				switch csiParam(params, 0) {
+				case 0:
+					if col < len(line) {
+						line = line[:col]
+					}
+				case 1:
+					for j := 0; j < col && j < len(line); j++ {
+						line[j] = " "
+					}
+				case 2:
+					line = line[:0]
+				}
+				rewritten = true
+			case 'C':
+				col = min(col+max(csiParam(params, 1), 1), maxColumn)
+			case 'D':
+				col = max(col-max(csiParam(params, 1), 1), 0)
+			case 'G':
+				col = min(max(csiParam(params, 1), 1), maxColumn) - 1
+			}
+			i += n
+			continue
+		case r == '\n':
+			flush()
+			out.WriteByte('\n')
+		case r == '\r':
+			col = 0
+		case r == '\b':
+			col = max(col-1, 0)
+		case r < 0x20 && r != '\t' || r == 0x7f:
+			// Other control characters (BEL, NUL padding, ...) are dropped
+		default:
+			put(output[i : i+size])
+		}
+		i += size
+	}
+	flush()
+
+	return out.String()
+}
+
+// parseEscape parses the escape sequence at the start of s and returns its
+// length. For CSI sequences it also returns the final byte and parameters;
+// final is 0 for any other sequence. An unterminated sequence runs to the
+// end of s.
+func parseEscape(s string) (n int, final byte, params string) {
+	if len(s) < 2 {
+		return len(s), 0, ""
+	}
+
+	switch s[1] {
+	case '[':
+		// CSI: parameter bytes, intermediate bytes, final byte
+		i := 2
+		for i < len(s) && s[i] >= 0x30 && s[i] <= 0x3f {
+			i++
+		}
+		end := i
+		for i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f {
+			i++
+		}
+		if i < len(s) && s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1, s[i], s[2:end]
+		}
+		return i, 0, ""
+	case ']', 'P', 'X', '^', '_':
+		// OSC, DCS and other strings end with ST (ESC \) or, for OSC, BEL
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' && s[1] == ']' {
+				return i + 1, 0, ""
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2, 0, ""
+			}
+		}
+		return len(s), 0, ""
+	default:
+		// Two-character (or charset designation) sequences
+		i := 1
+		for i < len(s) && s[i] >= 0x20 && s[i] <= 0x2f {
+			i++
+		}
+		if i < len(s) {
+			i++
+		}
+		return i, 0, ""
+	}
+}
+
+// csiParam returns the first numeric parameter of a CSI sequence, at most
+// maxColumn, or def when it is missing
+func csiParam(params string, def int) int {
+	first, _, _ := strings.Cut(params, ";")
+	n, err := strconv.Atoi(first)
+	if err != nil {
+		return def
+	}
+	return min(n, maxColumn)
+}
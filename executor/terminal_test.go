@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTerminal(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "crlf", input: "a\r\nb\r\n", want: "a\nb\n"},
+		{name: "colours", input: "\x1b[1;32mok\x1b[0m\n", want: "ok\n"},
+		{name: "erased pager prompt", input: "line1\n --More-- \b\b\b\b\b\b\b\b\b\b          \b\b\b\b\b\b\b\b\b\bline2\n", want: "line1\nline2\n"},
+		{name: "erase in line", input: " --More-- \r\x1b[Kline2\n", want: "line2\n"},
+		{name: "carriage return overwrite", input: "abcdef\rxy\n", want: "xycdef\n"},
+		{name: "cursor forward", input: "a\x1b[3Cb\n", want: "a   b\n"},
+		{name: "cursor back", input: "abc\x1b[2DX\n", want: "aXc\n"},
+		{name: "cursor column", input: "abc\x1b[5GX\n", want: "abc X\n"},
+		{name: "osc title", input: "\x1b]0;router\arouter#", want: "router#"},
+		{name: "control characters dropped", input: "a\x00\x07b\n", want: "ab\n"},
+		{name: "unterminated escape", input: "abc\x1b[", want: "abc"},
+		{name: "invalid utf-8 kept", input: "caf\xe9 \xff\xfe\n", want: "caf\xe9 \xff\xfe\n"},
+		{name: "invalid utf-8 overwritten", input: "\xe9\xe9\xe9\rab\n", want: "ab\xe9\n"},
+		{name: "multibyte overwritten", input: "日本語\rx\n", want: "x本語\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeTerminal(tt.input); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTerminalClampsColumns(t *testing.T) {
+	for _, input := range []string{
+		"\x1b[2147483647Gx",
+		"\x1b[2147483647Cx",
+		"\x1b[99999999999999999999999Gx",
+		"a\x1b[9223372036854775807Cx",
+	} {
+		got := normalizeTerminal(input)
+		if len(got) > maxColumn+1 {
+			t.Errorf("%q: output of %d bytes, want at most %d", input, len(got), maxColumn+1)
+		}
+		if !strings.HasSuffix(got, "x") {
+			t.Errorf("%q: output does not end with the character written", input)
+		}
+	}
+}
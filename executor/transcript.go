@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"bytes"
+
+	"github.com/zinrai/netback/config"
+)
+
+// transcriptTail is how much of the end of a CLI session is kept for
+// Result.Transcript
+const transcriptTail = 16 * 1024
+
+// tailWriter keeps the last max bytes written to it
+type tailWriter struct {
+	buf []byte
+	max int
+}
+
+func newTailWriter(max int) *tailWriter {
+	return &tailWriter{max: max}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	// Trim only once twice the size has accumulated, to copy rarely
+	if len(w.buf) > 2*w.max {
+		w.buf = append(w.buf[:0], w.buf[len(w.buf)-w.max:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the last max bytes written, starting at a line boundary
+// if the beginning was cut off
+func (w *tailWriter) String() string {
+	if len(w.buf) <= w.max {
+		return string(w.buf)
+	}
+	tail := w.buf[len(w.buf)-w.max:]
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return string(tail)
+}
+
+// cleanTranscript makes the raw end of a session readable: terminal
+// output is normalized and the model's secrets patterns are masked. Secrets
+// given as json_path do not apply to CLI sessions and are ignored.
+func cleanTranscript(raw string, model *config.Model) string {
+	transcript := normalizeTerminal(raw)
+	for _, secret := range model.Secrets {
+		if secret.JSONPath != "" {
+			continue
+		}
+		re, err := secret.Regex()
+		if err != nil {
+			continue
+		}
+		transcript = re.ReplaceAllString(transcript, secret.Replace)
+	}
+	return transcript
+}
@@ -1,27 +1,50 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/zinrai/netback/config"
 	"github.com/zinrai/netback/executor"
 	"github.com/zinrai/netback/output"
+	"github.com/zinrai/netback/transport"
 )
 
 var version = "0.1.0"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "model" {
+		os.Exit(runModelCommand(os.Args[2:]))
+	}
+
 	var (
 		routerdbPath  string
 		modelPath     string
 		outputDir     string
 		workers       int
 		defaultTimout time.Duration
+		knownHosts    string
+		hostKeyPolicy string
+		proxy         string
+		retries       int
+		retryBackoff  time.Duration
+		retryMax      time.Duration
+		retryJitter   float64
+		reportPath    string
+		metricsPath   string
+		junitPath     string
+		junitTail     int
+		logFormat     string
+		transcriptDir string
+		logLevel      string
 		showVersion   bool
 	)
 
@@ -30,6 +53,20 @@ func main() {
 	flag.StringVar(&outputDir, "output", "./configs", "Output directory")
 	flag.IntVar(&workers, "workers", 5, "Number of concurrent connections")
 	flag.DurationVar(&defaultTimout, "timeout", 30*time.Second, "Default connection timeout")
+	flag.StringVar(&knownHosts, "known-hosts", transport.DefaultKnownHostsPath(), "Path to known_hosts file")
+	flag.StringVar(&hostKeyPolicy, "host-key-policy", config.HostKeyPolicyStrict, "Host key policy: strict, tofu or insecure")
+	flag.StringVar(&proxy, "proxy", "", "Proxy URL for device connections (socks5://, socks5h:// or http://; default: $ALL_PROXY)")
+	flag.IntVar(&retries, "retries", 0, "Number of retries for devices failing with a transient error")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 5*time.Second, "Delay before the first retry, doubled for each further retry")
+	flag.DurationVar(&retryMax, "retry-max-backoff", time.Minute, "Maximum delay between retries (0 for 1h)")
+	flag.Float64Var(&retryJitter, "retry-jitter", 0.2, "Random variation of retry delays, as a fraction")
+	flag.StringVar(&reportPath, "report", "", "Write a JSON report of the run to this file")
+	flag.StringVar(&metricsPath, "metrics-file", "", "Write Prometheus metrics of the run to this file, for node_exporter's textfile collector")
+	flag.StringVar(&junitPath, "junit", "", "Write a JUnit XML report of the run to this file")
+	flag.IntVar(&junitTail, "junit-tail", 50, "Lines of the session transcript included for each failed device in the JUnit report")
+	flag.StringVar(&transcriptDir, "transcript-dir", "", "Record each device's CLI session to a transcript file in this directory")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format: text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "Log level: debug, info, warn or error")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.Parse()
 
@@ -40,10 +77,18 @@ func main() {
 
 	if routerdbPath == "" || modelPath == "" {
 		fmt.Fprintln(os.Stderr, "Usage: netback -routerdb <file> -model <file> [-output <dir>]")
+		fmt.Fprintln(os.Stderr, "       netback model test -model <file> [-update] <transcript>...")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
+	logger, err := newLogger(logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
 	// Load configurations
 	routerdb, err := config.LoadRouterDB(routerdbPath)
 	if err != nil {
@@ -57,6 +102,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	hostKeys, err := transport.NewKnownHosts(knownHosts, hostKeyPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading known_hosts: %v\n", err)
+		os.Exit(1)
+	}
+	if proxy == "" {
+		proxy = proxyFromEnvironment()
+	}
+	proxyURL, err := config.ParseProxy(proxy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	dialer := transport.NewDialer(hostKeys, proxyURL)
+
+	retry := config.RetryConfig{
+		Retries:    &retries,
+		Backoff:    &retryBackoff,
+		MaxBackoff: &retryMax,
+		Jitter:     &retryJitter,
+	}
+	if err := retry.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Prepare output
 	writer := output.NewWriter(outputDir)
 	if err := writer.EnsureDir(); err != nil {
@@ -64,31 +135,78 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Cancel in-flight sessions on SIGINT/SIGTERM; a second signal exits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		slog.Warn("cancelling in-flight sessions", "signal", sig.String())
+		signal.Stop(sigCh)
+		cancel()
+	}()
+
 	// Execute backups with concurrency control
-	results := executeBackups(routerdb, modelFile, writer, workers)
-
-	// Report results
-	var success, failed int
-	for _, r := range results {
-		if r.Error != nil {
-			failed++
-		} else {
-			success++
+	start := time.Now()
+	results := executeBackups(ctx, routerdb, modelFile, writer, &transport.Options{Dialer: dialer}, retry, workers, transcriptDir)
+	end := time.Now()
+	dialer.Close()
+
+	report := output.NewReport(version, start, end, results)
+	if reportPath != "" {
+		if err := output.WriteReport(reportPath, report); err != nil {
+			slog.Error("write report", "error", err)
+		}
+	}
+	if metricsPath != "" {
+		if err := output.WriteMetrics(metricsPath, report); err != nil {
+			slog.Error("write metrics", "error", err)
+		}
+	}
+	if junitPath != "" {
+		if err := output.WriteJUnit(junitPath, start, end, results, junitTail); err != nil {
+			slog.Error("write junit report", "error", err)
 		}
 	}
 
-	log.Printf("Completed: %d success, %d failed", success, failed)
+	// Report results, breaking failures down by class
+	totals := report.Totals
+	var failures []any
+	for _, class := range executor.Classes {
+		if n := totals.Failures[class]; n > 0 {
+			failures = append(failures, slog.Int(class, n))
+		}
+	}
+	slog.Info("completed",
+		"success", totals.Success,
+		"failed", totals.Failed,
+		"cancelled", totals.Cancelled,
+		slog.Group("failures", failures...),
+	)
 
-	if failed > 0 {
+	if totals.Failed > 0 || totals.Cancelled > 0 {
 		os.Exit(1)
 	}
 }
 
+// proxyFromEnvironment returns the proxy URL from ALL_PROXY or all_proxy
+func proxyFromEnvironment() string {
+	if p := os.Getenv("ALL_PROXY"); p != "" {
+		return p
+	}
+	return os.Getenv("all_proxy")
+}
+
 func executeBackups(
+	ctx context.Context,
 	routerdb *config.RouterDB,
 	modelFile *config.ModelFile,
 	writer *output.Writer,
+	opts *transport.Options,
+	retry config.RetryConfig,
 	workers int,
+	transcriptDir string,
 ) []*executor.Result {
 	results := make([]*executor.Result, 0, len(routerdb.Devices))
 	resultCh := make(chan *executor.Result, len(routerdb.Devices))
@@ -103,11 +221,9 @@ func executeBackups(
 
 		model, ok := modelFile.Models[device.Model]
 		if !ok {
-			log.Printf("%s: failed - model %q not found", device.Name, device.Model)
-			resultCh <- &executor.Result{
-				Device: device,
-				Error:  fmt.Errorf("model %q not found", device.Model),
-			}
+			result := modelNotFound(device)
+			logResult(result)
+			resultCh <- result
 			continue
 		}
 
@@ -115,25 +231,48 @@ func executeBackups(
 		go func(d *config.Device, m *config.Model) {
 			defer wg.Done()
 
-			// Acquire semaphore
-			sem <- struct{}{}
-			defer func() { <-sem }()
+			// Acquire semaphore, giving up if the run is cancelled first
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
 
-			result := executor.Execute(d, m)
+			var result *executor.Result
+			if ctx.Err() != nil {
+				now := time.Now()
+				result = &executor.Result{Device: d, Error: ctx.Err(), Start: now, End: now}
+			} else if transcriptDir != "" {
+				deviceOpts, closeTranscript := startTranscript(transcriptDir, d, opts)
+				result = executor.ExecuteWithRetry(ctx, d, m, deviceOpts, d.EffectiveRetry(retry))
+				closeTranscript()
+			} else {
+				result = executor.ExecuteWithRetry(ctx, d, m, opts, d.EffectiveRetry(retry))
+			}
 
 			// Write output if successful
 			if result.Error == nil {
-				if err := writer.Write(d.Name, d.Group, result.Output); err != nil {
-					result.Error = err
+				changed, err := writer.Write(d.Name, d.Group, result.Output)
+				if err != nil {
+					result.Error = &executor.WriteError{Path: writer.FilePath(d.Name, d.Group), Err: err}
+				} else {
+					result.BytesWritten += len(result.Output)
+					result.Changed = changed
 				}
 			}
-
-			if result.Error != nil {
-				log.Printf("%s: failed - %v", d.Name, result.Error)
-			} else {
-				log.Printf("%s: ok", d.Name)
+			if result.Error == nil && m.EffectiveFileOutput() == config.FileOutputSeparate {
+				for _, f := range result.Files {
+					changed, err := writer.WriteFile(d.Name, d.Group, f.Path, f.Content)
+					if err != nil {
+						result.Error = &executor.WriteError{Path: writer.RetrievedFilePath(d.Name, d.Group, f.Path), Err: err}
+						break
+					}
+					result.BytesWritten += len(f.Content)
+					result.Changed = result.Changed || changed
+				}
 			}
 
+			logResult(result)
 			resultCh <- result
 		}(device, model)
 	}
@@ -151,3 +290,80 @@ func executeBackups(
 
 	return results
 }
+
+// startTranscript creates the device's transcript file and returns options
+// recording its sessions there, and a function closing the file. If the
+// file cannot be created, the backup goes ahead without a transcript.
+func startTranscript(dir string, d *config.Device, opts *transport.Options) (*transport.Options, func()) {
+	logger := executor.DeviceLogger(nil, d)
+
+	f, err := output.CreateTranscript(dir, d.Name, d.Group)
+	if err != nil {
+		logger.Warn("not recording transcript", "error", err)
+		return opts, func() {}
+	}
+
+	recorder := transport.NewRecorder(f)
+	recorder.Note("netback %s transcript: device=%s group=%s model=%s transport=%s",
+		version, d.Name, d.Group, d.Model, d.EffectiveTransport())
+	deviceOpts := *opts
+	deviceOpts.Recorder = recorder
+
+	return &deviceOpts, func() {
+		err := recorder.Err()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			logger.Warn("transcript incomplete", "file", f.Name(), "error", err)
+		}
+	}
+}
+
+// modelNotFound returns the result of a device whose model is not
+// defined, which fails its only attempt without connecting
+func modelNotFound(device *config.Device) *executor.Result {
+	now := time.Now()
+	return &executor.Result{
+		Device:   device,
+		Error:    fmt.Errorf("model %q not found", device.Model),
+		Attempts: 1,
+		Start:    now,
+		End:      now,
+	}
+}
+
+// logResult logs the outcome of a device's backup
+func logResult(result *executor.Result) {
+	logger := executor.DeviceLogger(nil, result.Device)
+	attrs := []any{"attempts", result.Attempts, "duration_seconds", result.End.Sub(result.Start).Round(time.Millisecond).Seconds()}
+
+	switch {
+	case errors.Is(result.Error, context.Canceled):
+		logger.Warn("cancelled", attrs...)
+	case result.Error != nil:
+		attrs = append(attrs, "error_class", executor.Classify(result.Error), "error", result.Error)
+		logger.Error("failed", attrs...)
+	default:
+		attrs = append(attrs, "bytes", result.BytesWritten, "changed", result.Changed)
+		logger.Info("ok", attrs...)
+	}
+}
+
+// newLogger creates the logger for the -log-format and -log-level flags,
+// writing to standard error
+func newLogger(format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q (must be debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q (must be text or json)", format)
+}
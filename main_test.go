@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/executor"
+	"github.com/zinrai/netback/output"
+)
+
+func TestModelNotFoundReport(t *testing.T) {
+	device := &config.Device{Name: "core-01", Group: "dc-tokyo", Model: "nxos"}
+	now := time.Now()
+
+	r := output.NewReport("0.1.0", now, now, []*executor.Result{modelNotFound(device)})
+	d := r.Devices[0]
+	if d.Status != output.StatusFailed || d.ErrorClass != executor.ClassOther || d.Attempts != 1 {
+		t.Errorf("device = %+v, want a failed attempt of class other", d)
+	}
+	if d.Error != `model "nxos" not found` {
+		t.Errorf("error = %q", d.Error)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/executor"
+	"github.com/zinrai/netback/transport"
+)
+
+// runModelCommand runs "netback model <subcommand>" and returns the exit code
+func runModelCommand(args []string) int {
+	if len(args) == 0 || args[0] != "test" {
+		fmt.Fprintln(os.Stderr, "Usage: netback model test -model <file> [-update] <transcript>...")
+		return 1
+	}
+	return runModelTest(args[1:])
+}
+
+// runModelTest replays recorded transcripts through the model and compares
+// the backups they produce with golden files
+func runModelTest(args []string) int {
+	fs := flag.NewFlagSet("model test", flag.ExitOnError)
+	var (
+		modelPath string
+		modelName string
+		update    bool
+	)
+	fs.StringVar(&modelPath, "model", "", "Path to model.yaml")
+	fs.StringVar(&modelName, "name", "", "Model to test (default: the model recorded in each transcript)")
+	fs.BoolVar(&update, "update", false, "Write the golden files instead of comparing with them")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: netback model test -model <file> [-update] <transcript>...")
+		fmt.Fprintln(os.Stderr, "\nEach transcript is replayed through the model and the backup compared with the")
+		fmt.Fprintln(os.Stderr, "golden file next to it, named after the transcript with a .golden extension.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if modelPath == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	modelFile, err := config.LoadModelFile(modelPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading model file: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, path := range fs.Args() {
+		if err := testTranscript(path, modelFile, modelName, update); err != nil {
+			fmt.Printf("FAIL %s\n%v\n", path, err)
+			failed++
+			continue
+		}
+		if update {
+			fmt.Printf("UPDATED %s\n", goldenPath(path))
+		} else {
+			fmt.Printf("PASS %s\n", path)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d transcripts failed\n", failed, fs.NArg())
+		return 1
+	}
+	return 0
+}
+
+// testTranscript replays one transcript and compares the backup with its
+// golden file, or writes the golden file if update is set
+func testTranscript(path string, modelFile *config.ModelFile, modelName string, update bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	transcript, err := transport.ReadTranscript(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("read transcript: %w", err)
+	}
+
+	if modelName == "" {
+		modelName = transcript.Header["model"]
+	}
+	model, ok := modelFile.Models[modelName]
+	if !ok {
+		return fmt.Errorf("model %q not found", modelName)
+	}
+	// Retrieved files are not part of the CLI session
+	replayModel := *model
+	replayModel.Files = nil
+
+	device := &config.Device{
+		Name:      transcript.Header["device"],
+		Group:     transcript.Header["group"],
+		Model:     modelName,
+		Transport: transcript.Header["transport"],
+	}
+	t := transport.NewReplay(transcript, &replayModel)
+	result := executor.ExecuteTransport(context.Background(), t, device, &replayModel, executor.DeviceLogger(nil, device))
+	if result.Error != nil {
+		return fmt.Errorf("replay: %w", result.Error)
+	}
+
+	golden := goldenPath(path)
+	if update {
+		return os.WriteFile(golden, []byte(result.Output), 0644)
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		return fmt.Errorf("read golden file: %w (run with -update to create it)", err)
+	}
+	if string(want) != result.Output {
+		return fmt.Errorf("output differs from the golden file:\n--- %s\n+++ replay of %s\n%s", golden, path, diffLines(string(want), result.Output))
+	}
+	return nil
+}
+
+// goldenPath returns the golden file for a transcript
+func goldenPath(transcript string) string {
+	return strings.TrimSuffix(transcript, filepath.Ext(transcript)) + ".golden"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/transport"
+)
+
+// writeTranscript writes a transcript of a device named "core 01" running
+// show version, and returns its path
+func writeTranscript(t *testing.T, model string) string {
+	t.Helper()
+	line := func(marker, data string) string {
+		if marker != transport.TranscriptNote {
+			data = strconv.Quote(data)
+		}
+		return "2026-10-16T08:45:00.000000Z " + marker + " " + data + "\n"
+	}
+	data := line(transport.TranscriptNote, "netback 0.1.0 transcript: device=core 01 group=dc tokyo model="+model+" transport=ssh") +
+		line(transport.TranscriptNote, "attempt 1") +
+		line(transport.TranscriptReceived, "router#") +
+		line(transport.TranscriptSent, "show version\n") +
+		line(transport.TranscriptReceived, "show version\r\nVersion 17.9\r\nrouter#")
+
+	path := filepath.Join(t.TempDir(), "core-01.log")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTestTranscript(t *testing.T) {
+	modelFile := &config.ModelFile{Models: map[string]*config.Model{
+		"ios": {Prompt: `router#$`, Commands: []config.Command{{Cmd: "show version"}}},
+		"eos": {Prompt: `router#$`, Commands: []config.Command{{Cmd: "show running-config"}}},
+	}}
+	path := writeTranscript(t, "ios")
+	golden := goldenPath(path)
+	if golden != strings.TrimSuffix(path, ".log")+".golden" {
+		t.Fatalf("golden file = %s", golden)
+	}
+
+	// -update writes the golden file, which later runs compare with
+	if err := testTranscript(path, modelFile, "", true); err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(want), "Version 17.9") {
+		t.Errorf("golden file = %q", want)
+	}
+	if err := testTranscript(path, modelFile, "", false); err != nil {
+		t.Errorf("unchanged model: %v", err)
+	}
+
+	// A changed golden file shows a diff
+	if err := os.WriteFile(golden, []byte(strings.Replace(string(want), "17.9", "17.6", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = testTranscript(path, modelFile, "", false)
+	if err == nil || !strings.Contains(err.Error(), "output differs from the golden file") ||
+		!strings.Contains(err.Error(), "\n-Version 17.6") || !strings.Contains(err.Error(), "\n+Version 17.9") {
+		t.Errorf("err = %v, want a diff of the version line", err)
+	}
+
+	tests := []struct {
+		name      string
+		modelName string
+		err       string
+	}{
+		{name: "model sends another command", modelName: "eos", err: `sent "show running-config\n", but the transcript sends "show version\n"`},
+		{name: "model not found", modelName: "nxos", err: `model "nxos" not found`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testTranscript(path, modelFile, tt.modelName, false)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// Without a golden file the test asks for -update
+	os.Remove(golden)
+	if err := testTranscript(path, modelFile, "", false); err == nil || !strings.Contains(err.Error(), "run with -update") {
+		t.Errorf("err = %v, want a hint to run with -update", err)
+	}
+}
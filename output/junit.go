@@ -0,0 +1,148 @@
+package output
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/zinrai/netback/executor"
+)
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the results to path as a JUnit XML report: each device
+// is a test case in a test suite for its group. Failed devices carry the
+// last tailLines lines of their session transcript as system-out; cancelled
+// devices are reported as skipped.
+func WriteJUnit(path string, start, end time.Time, results []*executor.Result, tailLines int) error {
+	sorted := slices.Clone(results)
+	slices.SortFunc(sorted, func(a, b *executor.Result) int {
+		return cmp.Or(cmp.Compare(a.Device.Group, b.Device.Group), cmp.Compare(a.Device.Name, b.Device.Name))
+	})
+
+	root := junitTestSuites{Name: "netback", Time: junitSeconds(end.Sub(start))}
+	for _, r := range sorted {
+		if len(root.Suites) == 0 || root.Suites[len(root.Suites)-1].Name != r.Device.Group {
+			root.Suites = append(root.Suites, junitTestSuite{Name: r.Device.Group})
+		}
+		suite := &root.Suites[len(root.Suites)-1]
+
+		tc := junitTestCase{
+			Name:      r.Device.Name,
+			Classname: r.Device.Group,
+			Time:      junitSeconds(r.End.Sub(r.Start)),
+		}
+		switch Status(r) {
+		case StatusCancelled:
+			tc.Skipped = &junitSkipped{Message: r.Error.Error()}
+			suite.Skipped++
+		case StatusFailed:
+			tc.Failure = &junitFailure{
+				Message: r.Error.Error(),
+				Type:    executor.Classify(r.Error),
+				Text:    r.Error.Error(),
+			}
+			tc.SystemOut = lastLines(r.Transcript, tailLines)
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	for i := range root.Suites {
+		suite := &root.Suites[i]
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Skipped += suite.Skipped
+		first, last := suiteSpan(sorted, suite.Name)
+		suite.Timestamp = first.Format(time.RFC3339)
+		suite.Time = junitSeconds(last.Sub(first))
+	}
+
+	data, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode junit report: %w", err)
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := writeAtomic(path, data); err != nil {
+		return fmt.Errorf("write junit report: %w", err)
+	}
+	return nil
+}
+
+// suiteSpan returns the first start and the last end of the devices in
+// group. Devices run concurrently, so the span is shorter than the sum of
+// their times.
+func suiteSpan(results []*executor.Result, group string) (time.Time, time.Time) {
+	var start, end time.Time
+	for _, r := range results {
+		if r.Device.Group != group {
+			continue
+		}
+		if start.IsZero() || r.Start.Before(start) {
+			start = r.Start
+		}
+		if r.End.After(end) {
+			end = r.End
+		}
+	}
+	return start, end
+}
+
+// lastLines returns the last n lines of s
+func lastLines(s string, n int) string {
+	s = strings.TrimRight(s, "\n")
+	if n <= 0 || s == "" {
+		return ""
+	}
+	i := len(s)
+	for ; n > 0 && i > 0; n-- {
+		i = strings.LastIndexByte(s[:i], '\n')
+	}
+	return s[i+1:]
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
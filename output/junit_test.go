@@ -0,0 +1,126 @@
+package output
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/executor"
+	"github.com/zinrai/netback/transport"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 45, 0, 0, time.UTC)
+	result := func(name, group string, offset, took time.Duration, err error) *executor.Result {
+		return &executor.Result{
+			Device: &config.Device{Name: name, Group: group},
+			Error:  err,
+			Start:  start.Add(offset),
+			End:    start.Add(offset + took),
+		}
+	}
+
+	authErr := &transport.AuthError{Phase: transport.PhaseLogin, Err: errors.New("login incorrect")}
+	failed := result("leaf-01", "dc-tokyo", time.Second, 2*time.Second, authErr)
+	failed.Transcript = "Username: backup\nPassword: \n% Login invalid\n\nUsername: "
+	results := []*executor.Result{
+		failed,
+		result("core-01", "dc-tokyo", 0, 4*time.Second, nil),
+		result("edge-01", "dc-osaka", 0, time.Second, context.Canceled),
+	}
+
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	if err := WriteJUnit(path, start, start.Add(5*time.Second), results, 2); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), xml.Header) {
+		t.Errorf("report does not start with the XML header: %q", data)
+	}
+	var got junitTestSuites
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Name != "netback" || got.Tests != 3 || got.Failures != 1 || got.Skipped != 1 || got.Time != "5.000" {
+		t.Errorf("testsuites = %s %d tests %d failures %d skipped %s",
+			got.Name, got.Tests, got.Failures, got.Skipped, got.Time)
+	}
+	if len(got.Suites) != 2 {
+		t.Fatalf("%d test suites, want 2", len(got.Suites))
+	}
+
+	// Suites and cases are sorted by name
+	osaka, tokyo := got.Suites[0], got.Suites[1]
+	if osaka.Name != "dc-osaka" || osaka.Tests != 1 || osaka.Skipped != 1 || osaka.Failures != 0 {
+		t.Errorf("dc-osaka = %+v", osaka)
+	}
+	if tokyo.Name != "dc-tokyo" || tokyo.Tests != 2 || tokyo.Failures != 1 || tokyo.Skipped != 0 {
+		t.Errorf("dc-tokyo = %+v", tokyo)
+	}
+	// The suite spans its concurrent devices rather than adding them up
+	if tokyo.Timestamp != "2026-10-16T08:45:00Z" || tokyo.Time != "4.000" {
+		t.Errorf("dc-tokyo timestamp %s time %s, want 2026-10-16T08:45:00Z 4.000", tokyo.Timestamp, tokyo.Time)
+	}
+	if len(tokyo.Cases) != 2 || tokyo.Cases[0].Name != "core-01" || tokyo.Cases[1].Name != "leaf-01" {
+		t.Fatalf("dc-tokyo cases = %+v", tokyo.Cases)
+	}
+
+	core := tokyo.Cases[0]
+	if core.Classname != "dc-tokyo" || core.Time != "4.000" || core.Failure != nil || core.Skipped != nil || core.SystemOut != "" {
+		t.Errorf("core-01 = %+v", core)
+	}
+
+	leaf := tokyo.Cases[1]
+	if leaf.Failure == nil {
+		t.Fatal("leaf-01 has no failure")
+	}
+	if leaf.Failure.Message != authErr.Error() || leaf.Failure.Text != authErr.Error() {
+		t.Errorf("failure message %q text %q, want %q", leaf.Failure.Message, leaf.Failure.Text, authErr.Error())
+	}
+	if leaf.Failure.Type != executor.ClassAuth {
+		t.Errorf("failure type = %q, want %q", leaf.Failure.Type, executor.ClassAuth)
+	}
+	if want := "\nUsername: "; leaf.SystemOut != want {
+		t.Errorf("system-out = %q, want %q", leaf.SystemOut, want)
+	}
+
+	edge := osaka.Cases[0]
+	if edge.Skipped == nil || edge.Skipped.Message != context.Canceled.Error() || edge.Failure != nil {
+		t.Errorf("edge-01 = %+v, want skipped", edge)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{name: "fewer lines", s: "a\nb", n: 3, want: "a\nb"},
+		{name: "exact", s: "a\nb\nc", n: 3, want: "a\nb\nc"},
+		{name: "tail", s: "a\nb\nc\nd", n: 2, want: "c\nd"},
+		{name: "trailing newlines", s: "a\nb\nc\n\n", n: 2, want: "b\nc"},
+		{name: "leading newline", s: "\nb\nc", n: 2, want: "b\nc"},
+		{name: "blank lines kept", s: "a\n\nc", n: 2, want: "\nc"},
+		{name: "zero", s: "a\nb", n: 0, want: ""},
+		{name: "empty", s: "", n: 2, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lastLines(tt.s, tt.n); got != tt.want {
+				t.Errorf("lastLines(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
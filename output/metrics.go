@@ -0,0 +1,157 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zinrai/netback/executor"
+)
+
+// sample is one series of a metric, with its labels already rendered
+type sample struct {
+	labels string
+	value  float64
+}
+
+// WriteMetrics writes the report to path in the Prometheus text exposition
+// format, for node_exporter's textfile collector. The last success
+// timestamp of failed devices and the counters are carried over from the
+// file written by the previous run.
+func WriteMetrics(path string, r *Report) error {
+	previous, err := readMetrics(path)
+	if err != nil {
+		return fmt.Errorf("read previous metrics: %w", err)
+	}
+
+	var up, lastSuccess, duration, bytesWritten, attempts, failure []sample
+	for _, d := range r.Devices {
+		device := labels("device", d.Name, "group", d.Group, "model", d.Model)
+
+		up = append(up, sample{device, boolValue(d.Status == StatusSuccess)})
+		if d.Status == StatusSuccess {
+			lastSuccess = append(lastSuccess, sample{device, unixSeconds(d.End)})
+		} else if v, ok := previous["netback_device_last_success_timestamp_seconds"+device]; ok {
+			lastSuccess = append(lastSuccess, sample{device, v})
+		}
+		duration = append(duration, sample{device, d.Duration})
+		bytesWritten = append(bytesWritten, sample{device, float64(d.BytesWritten)})
+		attempts = append(attempts, sample{device, float64(d.Attempts)})
+		if d.ErrorClass != "" {
+			failure = append(failure, sample{labels("device", d.Name, "group", d.Group, "model", d.Model, "class", d.ErrorClass), 1})
+		}
+	}
+
+	statuses := map[string]int{
+		StatusSuccess:   r.Totals.Success,
+		StatusFailed:    r.Totals.Failed,
+		StatusCancelled: r.Totals.Cancelled,
+	}
+	var runDevices, backups []sample
+	for _, status := range []string{StatusSuccess, StatusFailed, StatusCancelled} {
+		l := labels("status", status)
+		runDevices = append(runDevices, sample{l, float64(statuses[status])})
+		backups = append(backups, sample{l, previous["netback_backups_total"+l] + float64(statuses[status])})
+	}
+	var failures []sample
+	for _, class := range executor.Classes {
+		if class == executor.ClassCancelled {
+			continue
+		}
+		l := labels("class", class)
+		failures = append(failures, sample{l, previous["netback_failures_total"+l] + float64(r.Totals.Failures[class])})
+	}
+
+	var b strings.Builder
+	writeMetric(&b, "netback_device_up", "gauge", "Whether the last backup of the device succeeded.", up)
+	writeMetric(&b, "netback_device_last_success_timestamp_seconds", "gauge", "Time of the last successful backup of the device.", lastSuccess)
+	writeMetric(&b, "netback_device_last_duration_seconds", "gauge", "Duration of the last backup of the device, including retries.", duration)
+	writeMetric(&b, "netback_device_bytes_written", "gauge", "Bytes saved by the last backup of the device.", bytesWritten)
+	writeMetric(&b, "netback_device_attempts", "gauge", "Attempts made by the last backup of the device.", attempts)
+	writeMetric(&b, "netback_device_failure", "gauge", "Failure class of the last backup of the device, if it failed.", failure)
+	writeMetric(&b, "netback_run_timestamp_seconds", "gauge", "Time the last run ended.", []sample{{"", unixSeconds(r.End)}})
+	writeMetric(&b, "netback_run_duration_seconds", "gauge", "Duration of the last run.", []sample{{"", r.Duration}})
+	writeMetric(&b, "netback_run_devices", "gauge", "Devices in the last run by status.", runDevices)
+	writeMetric(&b, "netback_runs_total", "counter", "Runs completed.", []sample{{"", previous["netback_runs_total"] + 1}})
+	writeMetric(&b, "netback_backups_total", "counter", "Device backups by status.", backups)
+	writeMetric(&b, "netback_failures_total", "counter", "Failed device backups by failure class.", failures)
+
+	if err := writeAtomic(path, []byte(b.String())); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+	return nil
+}
+
+// writeMetric writes the HELP and TYPE lines and the samples of a metric
+func writeMetric(b *strings.Builder, name, typ, help string, samples []sample) {
+	if len(samples) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, s := range samples {
+		fmt.Fprintf(b, "%s%s %s\n", name, s.labels, strconv.FormatFloat(s.value, 'f', -1, 64))
+	}
+}
+
+// readMetrics returns the samples in a metrics file written by
+// WriteMetrics, keyed by metric name and labels. A missing file has none.
+func readMetrics(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[string]float64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			metrics[line[:i]] = v
+		}
+	}
+	return metrics, scanner.Err()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels renders label name/value pairs as {name="value",...}
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// unixSeconds returns t as seconds since the epoch, to the millisecond
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
@@ -0,0 +1,157 @@
+package output
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/executor"
+	"github.com/zinrai/netback/transport"
+)
+
+// metricsReport returns the report of a run ending at end in which each
+// device in errs failed with its error, or succeeded if it is nil
+func metricsReport(end time.Time, errs map[string]error) *Report {
+	var results []*executor.Result
+	for name, err := range errs {
+		results = append(results, &executor.Result{
+			Device:   &config.Device{Name: name, Group: "dc-tokyo", Model: "ios"},
+			Error:    err,
+			Attempts: 1,
+			Start:    end.Add(-time.Second),
+			End:      end,
+		})
+	}
+	return NewReport("0.1.0", end.Add(-time.Second), end, results)
+}
+
+func TestWriteMetricsCarryOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netback.prom")
+	dialErr := &transport.DialError{Phase: transport.PhaseConnect, Addr: "192.0.2.2:22", Err: errors.New("connection refused")}
+	authErr := &transport.AuthError{Phase: transport.PhaseConnect, Err: errors.New("password rejected")}
+	first := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+
+	if err := WriteMetrics(path, metricsReport(first, map[string]error{"core-01": nil, "leaf-01": dialErr})); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMetrics(path, metricsReport(second, map[string]error{"core-01": authErr, "leaf-01": dialErr})); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readMetrics(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := `{device="core-01",group="dc-tokyo",model="ios"}`
+	leaf := `{device="leaf-01",group="dc-tokyo",model="ios"}`
+	want := map[string]float64{
+		"netback_runs_total":                        2,
+		`netback_backups_total{status="success"}`:   1,
+		`netback_backups_total{status="failed"}`:    3,
+		`netback_backups_total{status="cancelled"}`: 0,
+		`netback_failures_total{class="dial"}`:      2,
+		`netback_failures_total{class="auth"}`:      1,
+		`netback_failures_total{class="timeout"}`:   0,
+		`netback_run_devices{status="failed"}`:      2,
+		"netback_device_up" + core:                  0,
+		// The last success of core-01 is kept from the first run
+		"netback_device_last_success_timestamp_seconds" + core: unixSeconds(first),
+		"netback_run_timestamp_seconds":                        unixSeconds(second),
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("%s = %v, want %v", key, got[key], v)
+		}
+	}
+	if _, ok := got["netback_device_last_success_timestamp_seconds"+leaf]; ok {
+		t.Error("leaf-01 has a last success but never succeeded")
+	}
+	if got[`netback_device_failure{device="core-01",group="dc-tokyo",model="ios",class="auth"}`] != 1 {
+		t.Errorf("core-01 failure class missing: %v", got)
+	}
+}
+
+func TestWriteMetricsMalformedPrevious(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netback.prom")
+	previous := "garbage\n" +
+		"netback_runs_total abc\n" +
+		"netback_failures_total{class=\"dial\"}\n" +
+		"\x00\xff\n" +
+		"netback_backups_total{status=\"success\"} 4\n"
+	if err := os.WriteFile(path, []byte(previous), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines that cannot be read start over; the others carry over
+	end := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	if err := WriteMetrics(path, metricsReport(end, map[string]error{"core-01": nil})); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readMetrics(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		"netback_runs_total":                      1,
+		`netback_backups_total{status="success"}`: 5,
+		`netback_failures_total{class="dial"}`:    0,
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("%s = %v, want %v", key, got[key], v)
+		}
+	}
+	if _, ok := got["garbage"]; ok {
+		t.Error("garbage line carried over")
+	}
+
+	// A previous file that cannot be read at all fails the write rather
+	// than restarting the counters
+	if err := WriteMetrics(t.TempDir(), metricsReport(end, nil)); err == nil {
+		t.Error("reading a directory as the previous file succeeded")
+	}
+}
+
+func TestWriteMetricsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "netback.prom")
+	end := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	if err := WriteMetrics(path, metricsReport(end, map[string]error{"core-01": nil})); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the renamed file is left, readable by the collector
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "netback.prom" {
+		t.Errorf("directory holds %v, want netback.prom only", entries)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+
+	// A failed rename leaves no temporary file behind
+	blocked := filepath.Join(dir, "blocked.prom")
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(blocked, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeAtomic(blocked, []byte("netback_runs_total 1\n")); err == nil {
+		t.Error("writing over a directory succeeded")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("directory holds %v after a failed write", entries)
+	}
+}
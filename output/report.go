@@ -0,0 +1,139 @@
+package output
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/zinrai/netback/executor"
+)
+
+// Device statuses in a report
+const (
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Report is the machine-readable summary of a run
+type Report struct {
+	Version  string         `json:"version"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Duration float64        `json:"duration_seconds"`
+	Totals   ReportTotals   `json:"totals"`
+	Devices  []DeviceReport `json:"devices"`
+}
+
+// ReportTotals holds the run-level counts
+type ReportTotals struct {
+	Devices   int `json:"devices"`
+	Success   int `json:"success"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+	Changed   int `json:"changed"`
+	// Failures counts failed devices by error class
+	Failures map[string]int `json:"failures"`
+}
+
+// DeviceReport is the outcome of one device's backup
+type DeviceReport struct {
+	Name       string `json:"name"`
+	Group      string `json:"group"`
+	Model      string `json:"model"`
+	Transport  string `json:"transport"`
+	Status     string `json:"status"`
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Phase is the phase of the session the backup failed in, if known
+	Phase        string    `json:"phase,omitempty"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Duration     float64   `json:"duration_seconds"`
+	Attempts     int       `json:"attempts"`
+	BytesWritten int       `json:"bytes_written"`
+	Changed      bool      `json:"changed"`
+}
+
+// NewReport summarizes the results of a run. Devices are sorted by group
+// and name.
+func NewReport(version string, start, end time.Time, results []*executor.Result) *Report {
+	r := &Report{
+		Version:  version,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start).Seconds(),
+		Totals:   ReportTotals{Failures: make(map[string]int)},
+		Devices:  make([]DeviceReport, 0, len(results)),
+	}
+
+	for _, res := range results {
+		d := DeviceReport{
+			Name:         res.Device.Name,
+			Group:        res.Device.Group,
+			Model:        res.Device.Model,
+			Transport:    res.Device.EffectiveTransport(),
+			Status:       Status(res),
+			Start:        res.Start,
+			End:          res.End,
+			Duration:     res.End.Sub(res.Start).Seconds(),
+			Attempts:     res.Attempts,
+			BytesWritten: res.BytesWritten,
+			Changed:      res.Changed,
+		}
+		if res.Error != nil {
+			d.ErrorClass = executor.Classify(res.Error)
+			d.Error = res.Error.Error()
+			d.Phase = executor.FailurePhase(res.Error)
+		}
+
+		r.Totals.Devices++
+		switch d.Status {
+		case StatusSuccess:
+			r.Totals.Success++
+		case StatusCancelled:
+			r.Totals.Cancelled++
+		default:
+			r.Totals.Failed++
+			r.Totals.Failures[d.ErrorClass]++
+		}
+		if d.Changed {
+			r.Totals.Changed++
+		}
+
+		r.Devices = append(r.Devices, d)
+	}
+
+	slices.SortFunc(r.Devices, func(a, b DeviceReport) int {
+		return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Name, b.Name))
+	})
+
+	return r
+}
+
+// Status returns the status of a device's backup
+func Status(res *executor.Result) string {
+	switch {
+	case res.Error == nil:
+		return StatusSuccess
+	case errors.Is(res.Error, context.Canceled):
+		return StatusCancelled
+	}
+	return StatusFailed
+}
+
+// WriteReport writes the report as JSON to path
+func WriteReport(path string, r *Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode report: %w", err)
+	}
+	if err := writeAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"github.com/zinrai/netback/executor"
+	"github.com/zinrai/netback/transport"
+)
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 45, 0, 0, time.UTC)
+	result := func(name string, attempts int, err error) *executor.Result {
+		return &executor.Result{
+			Device:   &config.Device{Name: name, Group: "dc-tokyo", Model: "ios"},
+			Error:    err,
+			Attempts: attempts,
+			Start:    start,
+			End:      start.Add(2 * time.Second),
+		}
+	}
+
+	ok := result("core-01", 2, nil)
+	ok.BytesWritten, ok.Changed = 18219, true
+	results := []*executor.Result{
+		ok,
+		result("leaf-01", 3, &transport.DialError{Phase: transport.PhaseConnect, Addr: "192.0.2.1:22", Err: errors.New("connection refused")}),
+		result("leaf-02", 1, fmt.Errorf("post-login: %w", &transport.PromptTimeout{Phase: transport.PhasePostLogin, Command: "enable", Timeout: time.Second})),
+		result("leaf-03", 1, &executor.CommandError{Phase: executor.PhaseComment, Command: "show version", Err: errors.New("device reported an error: % Invalid input")}),
+		result("leaf-04", 1, errors.New("model \"nxos\" not found")),
+		result("edge-01", 0, context.Canceled),
+	}
+
+	r := NewReport("0.1.0", start, start.Add(3*time.Second), results)
+
+	want := []struct {
+		name, status, class, phase string
+		attempts                   int
+	}{
+		{"core-01", StatusSuccess, "", "", 2},
+		{"edge-01", StatusCancelled, executor.ClassCancelled, "", 0},
+		{"leaf-01", StatusFailed, executor.ClassDial, transport.PhaseConnect, 3},
+		{"leaf-02", StatusFailed, executor.ClassTimeout, transport.PhasePostLogin, 1},
+		{"leaf-03", StatusFailed, executor.ClassCommand, executor.PhaseComment, 1},
+		{"leaf-04", StatusFailed, executor.ClassOther, "", 1},
+	}
+	if len(r.Devices) != len(want) {
+		t.Fatalf("%d devices, want %d", len(r.Devices), len(want))
+	}
+	for i, w := range want {
+		d := r.Devices[i]
+		if d.Name != w.name || d.Status != w.status || d.ErrorClass != w.class || d.Phase != w.phase || d.Attempts != w.attempts {
+			t.Errorf("devices[%d] = %s %s %q %q attempts %d, want %s %s %q %q attempts %d",
+				i, d.Name, d.Status, d.ErrorClass, d.Phase, d.Attempts, w.name, w.status, w.class, w.phase, w.attempts)
+		}
+	}
+
+	core := r.Devices[0]
+	if core.Transport != config.TransportSSH || core.Duration != 2 || core.BytesWritten != 18219 || !core.Changed {
+		t.Errorf("core-01 = %+v", core)
+	}
+
+	totals := r.Totals
+	if totals.Devices != 6 || totals.Success != 1 || totals.Failed != 4 || totals.Cancelled != 1 || totals.Changed != 1 {
+		t.Errorf("totals = %+v", totals)
+	}
+	wantFailures := map[string]int{executor.ClassDial: 1, executor.ClassTimeout: 1, executor.ClassCommand: 1, executor.ClassOther: 1}
+	if !maps.Equal(totals.Failures, wantFailures) {
+		t.Errorf("failures = %v, want %v", totals.Failures, wantFailures)
+	}
+	if r.Duration != 3 {
+		t.Errorf("duration = %v, want 3", r.Duration)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 45, 0, 0, time.UTC)
+	results := []*executor.Result{
+		{Device: &config.Device{Name: "core-01"}, Attempts: 1, Start: start, End: start},
+		{
+			Device:   &config.Device{Name: "leaf-01"},
+			Error:    &transport.AuthError{Phase: transport.PhaseLogin, Err: errors.New("login incorrect")},
+			Attempts: 1,
+			Start:    start,
+			End:      start,
+		},
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := WriteReport(path, NewReport("0.1.0", start, start, results)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Version string           `json:"version"`
+		Devices []map[string]any `json:"devices"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "0.1.0" || len(got.Devices) != 2 {
+		t.Fatalf("report = %s", data)
+	}
+
+	// Failure fields are left out for a success
+	for _, key := range []string{"error_class", "error", "phase"} {
+		if _, ok := got.Devices[0][key]; ok {
+			t.Errorf("success has %q", key)
+		}
+	}
+	failed := got.Devices[1]
+	if failed["status"] != StatusFailed || failed["error_class"] != executor.ClassAuth ||
+		failed["phase"] != transport.PhaseLogin || failed["attempts"] != 1.0 {
+		t.Errorf("failed device = %v", failed)
+	}
+}
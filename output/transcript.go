@@ -0,0 +1,32 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// TranscriptPath returns the session transcript path for a device
+func TranscriptPath(dir, deviceName, group string) string {
+	return filepath.Join(dir, group, deviceName+".transcript")
+}
+
+// CreateTranscript creates the session transcript file for a device,
+// replacing the one from an earlier run. Transcripts hold raw device
+// output, so only the owner can read them.
+func CreateTranscript(dir, deviceName, group string) (*os.File, error) {
+	filename := TranscriptPath(dir, deviceName, group)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, fmt.Errorf("create transcript directory: %w", err)
+	}
+
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("create transcript: %w", err)
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("create transcript: %w", err)
+	}
+	return f, nil
+}
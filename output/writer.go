@@ -1,6 +1,7 @@
 package output
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -21,20 +22,70 @@ func (w *Writer) EnsureDir() error {
 	return os.MkdirAll(w.outputDir, 0755)
 }
 
-// Write writes the configuration to a file named after the device
-func (w *Writer) Write(deviceName, group, content string) error {
+// Write writes the configuration to a file named after the device and
+// reports whether it differs from the previous backup
+func (w *Writer) Write(deviceName, group, content string) (bool, error) {
 	dir := filepath.Join(w.outputDir, group)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("create group directory: %w", err)
+		return false, fmt.Errorf("create group directory: %w", err)
 	}
 
-	filename := filepath.Join(dir, deviceName)
+	return writeContent(filepath.Join(dir, deviceName), content)
+}
+
+// WriteFile writes a file retrieved from a device next to its backup, under
+// <device>.files/ with the device path kept below it, and reports whether
+// it differs from the previous copy
+func (w *Writer) WriteFile(deviceName, group, path, content string) (bool, error) {
+	filename := w.RetrievedFilePath(deviceName, group, path)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return false, fmt.Errorf("create files directory: %w", err)
+	}
+
+	return writeContent(filename, content)
+}
+
+// writeContent writes content to filename and reports whether it differs
+// from what the file held before (a new file counts as changed)
+func writeContent(filename, content string) (bool, error) {
+	previous, err := os.ReadFile(filename)
+	changed := err != nil || !bytes.Equal(previous, []byte(content))
 
 	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
-		return fmt.Errorf("write %s: %w", filename, err)
+		return false, fmt.Errorf("write %s: %w", filename, err)
 	}
 
-	return nil
+	return changed, nil
+}
+
+// writeAtomic writes data to a temporary file next to filename and renames
+// it into place, so that readers never see a partial file
+func writeAtomic(filename string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// RetrievedFilePath returns the output path for a file retrieved from a device.
+// Cleaning the path as if rooted keeps ".." from escaping the directory.
+func (w *Writer) RetrievedFilePath(deviceName, group, path string) string {
+	rel := filepath.Clean("/" + filepath.FromSlash(path))
+	return filepath.Join(w.outputDir, group, deviceName+".files", rel)
 }
 
 // FilePath returns the output file path for a device
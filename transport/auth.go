@@ -0,0 +1,135 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// authMethods builds the SSH authentication methods for the credentials.
+// Methods are offered in order: public key (certificate first, then the
+// agent's keys), password. The client tries each method once, so the key
+// file and the agent share one public key method. The returned closer
+// releases the agent connection and must be called once the handshake has
+// finished.
+func authMethods(cred *config.Credentials) ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closer := func() {}
+
+	var signers []ssh.Signer
+	if cred.PrivateKey != "" {
+		var err error
+		if signers, err = loadSigners(cred); err != nil {
+			return nil, closer, err
+		}
+	}
+
+	var agentClient agent.ExtendedAgent
+	if cred.UseAgent {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, closer, fmt.Errorf("use_agent is set but SSH_AUTH_SOCK is empty")
+		}
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, closer, fmt.Errorf("connect to ssh agent: %w", err)
+		}
+		closer = func() { conn.Close() }
+		agentClient = agent.NewClient(conn)
+	}
+
+	if len(signers) > 0 || agentClient != nil {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agentClient == nil {
+				return signers, nil
+			}
+			agentSigners, err := agentClient.Signers()
+			if err != nil {
+				return nil, fmt.Errorf("list ssh agent keys: %w", err)
+			}
+			return append(slices.Clip(signers), agentSigners...), nil
+		}))
+	}
+
+	if cred.Password != "" {
+		password := cred.Password
+		methods = append(methods,
+			ssh.Password(password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range questions {
+					answers[i] = password
+				}
+				return answers, nil
+			}),
+		)
+	}
+
+	return methods, closer, nil
+}
+
+// loadSigners reads the private key and, if configured, its certificate
+func loadSigners(cred *config.Credentials) ([]ssh.Signer, error) {
+	data, err := os.ReadFile(expandHome(cred.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("read private key: %w", err)
+	}
+
+	var signer ssh.Signer
+	if cred.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(cred.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key %s is encrypted: passphrase is required", cred.PrivateKey)
+		}
+		return nil, fmt.Errorf("parse private key %s: %w", cred.PrivateKey, err)
+	}
+
+	if cred.Certificate == "" {
+		return []ssh.Signer{signer}, nil
+	}
+
+	certData, err := os.ReadFile(expandHome(cred.Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("read certificate: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certData)
+	if err != nil {
+		return nil, fmt.Errorf("parse certificate %s: %w", cred.Certificate, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ssh certificate", cred.Certificate)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s: %w", cred.Certificate, err)
+	}
+
+	// Offer the certificate first, falling back to the plain key
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+// expandHome replaces a leading ~/ with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
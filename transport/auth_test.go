@@ -0,0 +1,315 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// authLog records the authentication methods a server was offered, and
+// whether each was accepted
+type authLog struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (l *authLog) callback(_ ssh.ConnMetadata, method string, err error) {
+	if method == "none" {
+		return
+	}
+	entry := method + ":ok"
+	if err != nil {
+		entry = method + ":rejected"
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// A public key is checked once before it signs anything
+	if n := len(l.methods); n == 0 || l.methods[n-1] != entry {
+		l.methods = append(l.methods, entry)
+	}
+}
+
+func (l *authLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.methods)
+}
+
+// writeKey writes priv in OpenSSH format, encrypted if passphrase is set,
+// and returns its path
+func writeKey(t *testing.T, priv ed25519.PrivateKey, passphrase string) string {
+	t.Helper()
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serveAgent serves an ssh-agent holding priv and points SSH_AUTH_SOCK at it
+func serveAgent(t *testing.T, priv ed25519.PrivateKey) {
+	t.Helper()
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+}
+
+func newKey(t *testing.T) (ed25519.PrivateKey, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub
+}
+
+func TestAuthMethods(t *testing.T) {
+	hostKey, pinned := sshServerKey(t)
+	filePriv, filePub := newKey(t)
+	agentPriv, agentPub := newKey(t)
+	caPriv, _ := newKey(t)
+	caSigner, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A user certificate for the key file, signed by the CA
+	cert := &ssh.Certificate{
+		Key:             filePub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"backup"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(t.TempDir(), "id_ed25519-cert.pub")
+	if err := os.WriteFile(certPath, ssh.MarshalAuthorizedKey(cert), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := writeKey(t, filePriv, "")
+	encryptedPath := writeKey(t, filePriv, "key-passphrase")
+
+	// Servers accepting one kind of authentication each
+	keyOnly := func(accepted ...ssh.PublicKey) *ssh.ServerConfig {
+		return &ssh.ServerConfig{
+			PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				for _, k := range accepted {
+					if bytes.Equal(k.Marshal(), key.Marshal()) {
+						return nil, nil
+					}
+				}
+				return nil, fmt.Errorf("key rejected")
+			},
+		}
+	}
+	certOnly := func() *ssh.ServerConfig {
+		checker := &ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), caSigner.PublicKey().Marshal())
+			},
+		}
+		return &ssh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+	}
+	passwordOnly := func() *ssh.ServerConfig {
+		return &ssh.ServerConfig{
+			PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				if string(password) == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("password rejected")
+			},
+		}
+	}
+	keyboardInteractiveOnly := func() *ssh.ServerConfig {
+		return &ssh.ServerConfig{
+			KeyboardInteractiveCallback: func(c ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+				answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+				if err != nil {
+					return nil, err
+				}
+				if len(answers) == 1 && answers[0] == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("password rejected")
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		server *ssh.ServerConfig
+		cred   config.Credentials
+		agent  bool
+		// want lists the methods tried, in order; nil expects an AuthError
+		want []string
+	}{
+		{
+			name:   "key file",
+			server: keyOnly(filePub),
+			cred:   config.Credentials{PrivateKey: keyPath, Password: "secret"},
+			want:   []string{"publickey:ok"},
+		},
+		{
+			name:   "encrypted key file",
+			server: keyOnly(filePub),
+			cred:   config.Credentials{PrivateKey: encryptedPath, Passphrase: "key-passphrase"},
+			want:   []string{"publickey:ok"},
+		},
+		{
+			name:   "certificate",
+			server: certOnly(),
+			cred:   config.Credentials{PrivateKey: keyPath, Certificate: certPath},
+			want:   []string{"publickey:ok"},
+		},
+		{
+			name:   "agent after key file",
+			server: keyOnly(agentPub),
+			cred:   config.Credentials{PrivateKey: keyPath, UseAgent: true, Password: "secret"},
+			agent:  true,
+			want:   []string{"publickey:rejected", "publickey:ok"},
+		},
+		{
+			name:   "password after keys",
+			server: passwordOnly(),
+			cred:   config.Credentials{PrivateKey: keyPath, UseAgent: true, Password: "secret"},
+			agent:  true,
+			want:   []string{"password:ok"},
+		},
+		{
+			name:   "keyboard-interactive",
+			server: keyboardInteractiveOnly(),
+			cred:   config.Credentials{Password: "secret"},
+			want:   []string{"keyboard-interactive:ok"},
+		},
+		{
+			name:   "key-only server without a key",
+			server: keyOnly(filePub),
+			cred:   config.Credentials{Password: "secret"},
+		},
+		{
+			name:   "password-only server with a wrong password",
+			server: passwordOnly(),
+			cred:   config.Credentials{PrivateKey: keyPath, Password: "wrong"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.agent {
+				serveAgent(t, agentPriv)
+			}
+			var log authLog
+			tt.server.AuthLogCallback = log.callback
+			tt.server.AddHostKey(hostKey)
+			host, port := serveSSH(t, tt.server, nil)
+
+			device := &config.Device{Name: "r1", IP: host, Port: port, Timeout: 5 * time.Second}
+			device.Credentials = tt.cred
+			device.Username = "backup"
+			device.HostKey = pinned
+			opts := &Options{Dialer: NewDialer(nil, nil), Logger: discardLogger}
+
+			client, err := dialSSH(context.Background(), device, &config.Model{}, opts)
+			if tt.want == nil {
+				var authErr *AuthError
+				if !errors.As(err, &authErr) {
+					t.Fatalf("err = %v, want an AuthError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Close()
+
+			got := log.get()
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("methods = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMethodsErrors(t *testing.T) {
+	priv, _ := newKey(t)
+	encryptedPath := writeKey(t, priv, "key-passphrase")
+
+	tests := []struct {
+		name string
+		cred config.Credentials
+		err  string
+	}{
+		{
+			name: "passphrase missing",
+			cred: config.Credentials{PrivateKey: encryptedPath},
+			err:  "private key " + encryptedPath + " is encrypted: passphrase is required",
+		},
+		{
+			name: "agent without SSH_AUTH_SOCK",
+			cred: config.Credentials{UseAgent: true},
+			err:  "use_agent is set but SSH_AUTH_SOCK is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_SOCK", "")
+			_, closer, err := authMethods(&tt.cred)
+			closer()
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// closeOnDone closes c once ctx is done, unblocking any pending read or
+// write on it. The returned function stops the watch; it returns the
+// context error, described by what, if c was closed. It may be called
+// more than once.
+func closeOnDone(ctx context.Context, c io.Closer, what string) func() error {
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() {
+			if !stop() {
+				err = contextError(ctx, what)
+			}
+		})
+		return err
+	}
+}
+
+// contextError describes why ctx is done, e.g. "timeout waiting for prompt"
+func contextError(ctx context.Context, what string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timeout %s: %w", what, ctx.Err())
+	}
+	return fmt.Errorf("cancelled %s: %w", what, ctx.Err())
+}
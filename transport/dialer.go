@@ -0,0 +1,247 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Dialer opens connections to devices, through a proxy and jump hosts when
+// configured. Jump host connections are shared by all workers.
+type Dialer struct {
+	hostKeys *KnownHosts
+	// proxy is used by devices without a proxy of their own
+	proxy *url.URL
+	mu    sync.Mutex
+	jumps map[string]*jumpConn
+}
+
+// jumpConn is a cached connection to a jump host
+type jumpConn struct {
+	// hop is the position of the jump host in its chain, counting from 1
+	hop    int
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewDialer creates a dialer verifying host keys with hostKeys. proxy, if
+// not nil, is used for devices that do not set their own.
+func NewDialer(hostKeys *KnownHosts, proxy *url.URL) *Dialer {
+	return &Dialer{
+		hostKeys: hostKeys,
+		proxy:    proxy,
+		jumps:    make(map[string]*jumpConn),
+	}
+}
+
+// Dial connects to addr for the device, through its proxy and jump hosts if any.
+// The proxy is used to reach the device, or the first jump host. logger
+// receives the messages about connecting to jump hosts.
+func (d *Dialer) Dial(ctx context.Context, logger *slog.Logger, device *config.Device, addr string) (net.Conn, error) {
+	proxy, err := d.proxyFor(device)
+	if err != nil {
+		return nil, err
+	}
+	timeout := device.EffectiveTimeout()
+
+	if len(device.Jump) == 0 {
+		return dialTCP(ctx, proxy, addr, timeout)
+	}
+
+	bastion, err := d.jumpClient(ctx, logger, proxy, device.Jump)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := bastion.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		last := device.Jump[len(device.Jump)-1]
+		return nil, &DialError{Phase: PhaseConnect, Addr: addr, Via: last.Host, Err: err}
+	}
+	return conn, nil
+}
+
+// proxyFor returns the proxy for the device: its own, "direct" for none,
+// or the dialer's default
+func (d *Dialer) proxyFor(device *config.Device) (*url.URL, error) {
+	if device.Proxy == "" {
+		return d.proxy, nil
+	}
+	return config.ParseProxy(device.Proxy)
+}
+
+// dialTCP connects to addr directly or through proxy
+func dialTCP(ctx context.Context, proxy *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	if proxy != nil {
+		conn, err := dialProxy(ctx, proxy, addr, timeout)
+		if err != nil {
+			return nil, &DialError{Phase: PhaseConnect, Addr: addr, Via: "proxy " + proxy.Host, Err: err}
+		}
+		return conn, nil
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &DialError{Phase: PhaseConnect, Addr: addr, Err: err}
+	}
+	return conn, nil
+}
+
+// jumpClient returns a connected client for the last hop of the chain,
+// establishing each hop on first use
+func (d *Dialer) jumpClient(ctx context.Context, logger *slog.Logger, proxy *url.URL, jumps []config.JumpHost) (*ssh.Client, error) {
+	var prev *ssh.Client
+
+	for i := range jumps {
+		key := jumpKey(proxy, jumps[:i+1])
+
+		d.mu.Lock()
+		jc, ok := d.jumps[key]
+		if !ok {
+			jc = &jumpConn{hop: i + 1}
+			d.jumps[key] = jc
+		}
+		d.mu.Unlock()
+
+		client, err := jc.get(ctx, logger, &jumps[i], proxy, prev, d.hostKeys)
+		if err != nil {
+			return nil, err
+		}
+		prev = client
+	}
+
+	return prev, nil
+}
+
+// get returns the cached client, connecting if there is none yet
+func (jc *jumpConn) get(ctx context.Context, logger *slog.Logger, jump *config.JumpHost, proxy *url.URL, via *ssh.Client, hostKeys *KnownHosts) (*ssh.Client, error) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	if jc.client != nil {
+		return jc.client, nil
+	}
+
+	client, err := connectJump(ctx, logger, jump, proxy, via, hostKeys)
+	if err != nil {
+		return nil, err
+	}
+	jc.client = client
+
+	// Forget the connection once it goes away so the next device reconnects
+	go func() {
+		client.Wait()
+		jc.mu.Lock()
+		if jc.client == client {
+			jc.client = nil
+		}
+		jc.mu.Unlock()
+	}()
+
+	return client, nil
+}
+
+// connectJump establishes an SSH connection to a jump host, through another
+// one if via is set, or else through proxy if set
+func connectJump(ctx context.Context, logger *slog.Logger, jump *config.JumpHost, proxy *url.URL, via *ssh.Client, hostKeys *KnownHosts) (*ssh.Client, error) {
+	addr := net.JoinHostPort(jump.Host, strconv.Itoa(jump.EffectivePort()))
+	logger.Info("connecting to jump host", "phase", PhaseConnect, "jump_host", addr)
+
+	auth, closeAuth, err := authMethods(&jump.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("jump host %s auth: %w", jump.Host, err)
+	}
+	defer closeAuth()
+
+	sshConfig := &ssh.ClientConfig{
+		User:              jump.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys.Callback(logger, &jump.HostKeyConfig),
+		HostKeyAlgorithms: hostKeys.HostKeyAlgorithms(&jump.HostKeyConfig, addr),
+		Timeout:           jump.EffectiveTimeout(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jump.EffectiveTimeout())
+	defer cancel()
+
+	var conn net.Conn
+	if via == nil {
+		conn, err = dialTCP(ctx, proxy, addr, jump.EffectiveTimeout())
+	} else if conn, err = via.DialContext(ctx, "tcp", addr); err != nil {
+		err = &DialError{Phase: PhaseConnect, Addr: addr, Err: err}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jump host: %w", err)
+	}
+
+	sshConn, chans, reqs, err := handshake(ctx, conn, addr, sshConfig)
+	if err != nil {
+		err = fmt.Errorf("jump host %s handshake: %w", addr, err)
+		if isAuthFailure(err) {
+			return nil, &AuthError{Phase: PhaseConnect, Err: err}
+		}
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// jumpKey identifies a chain of jump hosts and the proxy used to reach it.
+// Every setting of each hop is part of the key, so the same host reached
+// with other credentials or host key settings gets a connection of its own.
+func jumpKey(proxy *url.URL, jumps []config.JumpHost) string {
+	hops := make([]string, len(jumps))
+	for i, j := range jumps {
+		hops[i] = fmt.Sprintf("%#v", j)
+	}
+	key := strings.Join(hops, "\n")
+	if proxy != nil {
+		key = proxy.String() + "\n" + key
+	}
+	return key
+}
+
+// Close closes all jump host connections
+func (d *Dialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Close the innermost hops first, while their tunnels are still up
+	conns := make([]*jumpConn, 0, len(d.jumps))
+	for _, jc := range d.jumps {
+		conns = append(conns, jc)
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].hop > conns[j].hop
+	})
+
+	var errs []error
+	for _, jc := range conns {
+		jc.mu.Lock()
+		if jc.client != nil {
+			if err := jc.client.Close(); err != nil {
+				errs = append(errs, err)
+			}
+			jc.client = nil
+		}
+		jc.mu.Unlock()
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("close errors: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+)
+
+func TestJumpClientShared(t *testing.T) {
+	hostKey, pinned := sshServerKey(t)
+	host, port := sshServer(t, hostKey, "secret")
+
+	hostKeys, err := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"), config.HostKeyPolicyStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDialer(hostKeys, nil)
+	t.Cleanup(func() { d.Close() })
+
+	jump := func(password string, hk config.HostKeyConfig) []config.JumpHost {
+		j := config.JumpHost{Host: host, Port: port, Timeout: 5 * time.Second, HostKeyConfig: hk}
+		j.Username = "backup"
+		j.Password = password
+		return []config.JumpHost{j}
+	}
+	pinnedKey := config.HostKeyConfig{HostKey: pinned}
+
+	ctx := context.Background()
+	first, err := d.jumpClient(ctx, discardLogger, nil, jump("secret", pinnedKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, err := d.jumpClient(ctx, discardLogger, nil, jump("secret", pinnedKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same != first {
+		t.Error("the same jump host settings did not share a connection")
+	}
+
+	other, err := d.jumpClient(ctx, discardLogger, nil, jump("secret", config.HostKeyConfig{HostKeyPolicy: config.HostKeyPolicyInsecure}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == first {
+		t.Error("other host key settings shared a connection")
+	}
+
+	// Other credentials authenticate on their own connection
+	_, err = d.jumpClient(ctx, discardLogger, nil, jump("wrong", pinnedKey))
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Errorf("other password: err = %v, want an AuthError", err)
+	}
+}
@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session phases reported by DialError, HostKeyError, AuthError and PromptTimeout
+const (
+	// PhaseConnect covers dialing, the SSH handshake and the initial prompt
+	PhaseConnect = "connect"
+	// PhaseLogin is the Telnet login dialogue
+	PhaseLogin = "login"
+	// PhasePostLogin runs the model's post_login commands
+	PhasePostLogin = "post-login"
+	// PhaseCommand runs the model's comments and commands
+	PhaseCommand = "command"
+)
+
+// DialError is returned when the device, or a jump host or proxy on the
+// way to it, cannot be reached
+type DialError struct {
+	// Phase is PhaseConnect, the only phase that dials
+	Phase string
+	Addr  string
+	// Via names the jump host or proxy the connection went through, if any
+	Via string
+	Err error
+}
+
+func (e *DialError) Error() string {
+	if e.Via != "" {
+		return fmt.Sprintf("dial %s via %s: %v", e.Addr, e.Via, e.Err)
+	}
+	return fmt.Sprintf("dial %s: %v", e.Addr, e.Err)
+}
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+// AuthError is returned when the device, a jump host or a proxy rejects
+// the credentials
+type AuthError struct {
+	// Phase is PhaseConnect for SSH, proxy and HTTP authentication and
+	// PhaseLogin for the Telnet login dialogue
+	Phase string
+	Err   error
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// PromptTimeout is returned when the prompt (or a login or enable prompt)
+// does not appear within the timeout
+type PromptTimeout struct {
+	Phase string
+	// Command is the command whose output was being read, if any
+	Command string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *PromptTimeout) Error() string {
+	return fmt.Sprintf("timeout after %s waiting for prompt", e.Timeout)
+}
+
+func (e *PromptTimeout) Unwrap() error { return e.Err }
+
+// SessionLost is returned when a command fails in a way that leaves the
+// connection unusable for the commands after it, so the backup cannot go
+// on even if the command is optional
+type SessionLost struct {
+	Err error
+}
+
+func (e *SessionLost) Error() string { return "session lost: " + e.Err.Error() }
+
+func (e *SessionLost) Unwrap() error { return e.Err }
+
+// promptTimeout turns err into a PromptTimeout if the read was cut short
+// by its deadline
+func promptTimeout(err error, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &PromptTimeout{Timeout: timeout, Err: err}
+	}
+	return err
+}
+
+// withPhase records the phase and command on a PromptTimeout in err's chain
+func withPhase(err error, phase, command string) error {
+	var pt *PromptTimeout
+	if errors.As(err, &pt) {
+		pt.Phase, pt.Command = phase, command
+	}
+	return err
+}
+
+// isAuthFailure reports whether an SSH handshake failed because no
+// authentication method was accepted. The x/crypto/ssh client reports this
+// with an untyped error (ssh.ServerAuthError and ssh.ErrNoAuth are only
+// returned by its server), so the message is matched; TestDialSSHErrors
+// checks it against a real handshake.
+func isAuthFailure(err error) bool {
+	return strings.Contains(err.Error(), "ssh: unable to authenticate")
+}
@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyError is returned when a device presents a host key that cannot be verified
+type HostKeyError struct {
+	// Phase is PhaseConnect: host keys are checked during the SSH handshake
+	Phase       string
+	Host        string
+	Fingerprint string
+	// Known holds fingerprints of the keys on record. Empty means the host is unknown.
+	Known   []string
+	Revoked bool
+}
+
+func (e *HostKeyError) Error() string {
+	switch {
+	case e.Revoked:
+		return fmt.Sprintf("host key for %s is revoked (%s)", e.Host, e.Fingerprint)
+	case len(e.Known) == 0:
+		return fmt.Sprintf("host key for %s is unknown (%s)", e.Host, e.Fingerprint)
+	default:
+		return fmt.Sprintf("host key mismatch for %s: got %s, want %s",
+			e.Host, e.Fingerprint, strings.Join(e.Known, ", "))
+	}
+}
+
+// KnownHosts verifies device host keys against an OpenSSH known_hosts file
+type KnownHosts struct {
+	path     string
+	policy   string
+	mu       sync.Mutex
+	callback ssh.HostKeyCallback
+}
+
+// DefaultKnownHostsPath returns ~/.ssh/known_hosts
+func DefaultKnownHostsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".ssh", "known_hosts")
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// NewKnownHosts loads the known_hosts file at path. A missing file is treated as empty.
+func NewKnownHosts(path, policy string) (*KnownHosts, error) {
+	if !config.ValidHostKeyPolicy(policy) {
+		return nil, fmt.Errorf("unknown host key policy %q", policy)
+	}
+
+	k := &KnownHosts{path: path, policy: policy}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KnownHosts) load() error {
+	var files []string
+	if _, err := os.Stat(k.path); err == nil {
+		files = append(files, k.path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stat known_hosts: %w", err)
+	}
+
+	cb, err := knownhosts.New(files...)
+	if err != nil {
+		return fmt.Errorf("load known_hosts: %w", err)
+	}
+	k.callback = cb
+	return nil
+}
+
+// Callback returns the host key callback for a device or jump host.
+// logger receives a message when a key is added to known_hosts.
+func (k *KnownHosts) Callback(logger *slog.Logger, hk *config.HostKeyConfig) ssh.HostKeyCallback {
+	if hk.HostKey != "" {
+		return pinnedHostKey(hk.HostKey)
+	}
+
+	policy := k.policy
+	if hk.HostKeyPolicy != "" {
+		policy = hk.HostKeyPolicy
+	}
+
+	if policy == config.HostKeyPolicyInsecure {
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+
+		err := k.callback(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		var revokedErr *knownhosts.RevokedError
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &revokedErr):
+			return &HostKeyError{Phase: PhaseConnect, Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Revoked: true}
+		case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+			known := make([]string, 0, len(keyErr.Want))
+			for _, w := range keyErr.Want {
+				known = append(known, ssh.FingerprintSHA256(w.Key))
+			}
+			return &HostKeyError{Phase: PhaseConnect, Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Known: known}
+		case errors.As(err, &keyErr) && policy == config.HostKeyPolicyTOFU:
+			return k.add(logger, hostname, key)
+		case errors.As(err, &keyErr):
+			return &HostKeyError{Phase: PhaseConnect, Host: hostname, Fingerprint: ssh.FingerprintSHA256(key)}
+		default:
+			return err
+		}
+	}
+}
+
+// HostKeyAlgorithms returns the host key algorithms matching the keys on record
+// for addr, so that the server is asked for a key we can actually verify.
+// It returns nil when nothing is known about the host.
+func (k *KnownHosts) HostKeyAlgorithms(hk *config.HostKeyConfig, addr string) []string {
+	var keyTypes []string
+
+	if hk.HostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hk.HostKey))
+		if err != nil {
+			return nil
+		}
+		keyTypes = append(keyTypes, key.Type())
+	} else {
+		k.mu.Lock()
+		err := k.callback(addr, &net.TCPAddr{}, probeKey{})
+		k.mu.Unlock()
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return nil
+		}
+		for _, w := range keyErr.Want {
+			keyTypes = append(keyTypes, w.Key.Type())
+		}
+	}
+
+	var algos []string
+	for _, t := range keyTypes {
+		for _, a := range algorithmsForKeyType(t) {
+			if !slices.Contains(algos, a) {
+				algos = append(algos, a)
+			}
+		}
+	}
+	return algos
+}
+
+// add appends a newly seen host key to the known_hosts file (caller holds k.mu)
+func (k *KnownHosts) add(logger *slog.Logger, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(k.path), 0700); err != nil {
+		return fmt.Errorf("create known_hosts directory: %w", err)
+	}
+
+	f, err := os.OpenFile(k.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open known_hosts: %w", err)
+	}
+
+	line := knownhosts.Line([]string{hostname}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return fmt.Errorf("write known_hosts: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write known_hosts: %w", err)
+	}
+
+	logger.Warn("added host key to known_hosts", "phase", PhaseConnect,
+		"host", knownhosts.Normalize(hostname), "fingerprint", ssh.FingerprintSHA256(key), "file", k.path)
+
+	return k.load()
+}
+
+// pinnedHostKey accepts only the key configured on the device
+func pinnedHostKey(hostKey string) ssh.HostKeyCallback {
+	want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err != nil {
+			return fmt.Errorf("parse host_key: %w", err)
+		}
+		if !bytes.Equal(want.Marshal(), key.Marshal()) {
+			return &HostKeyError{
+				Phase:       PhaseConnect,
+				Host:        hostname,
+				Fingerprint: ssh.FingerprintSHA256(key),
+				Known:       []string{ssh.FingerprintSHA256(want)},
+			}
+		}
+		return nil
+	}
+}
+
+// algorithmsForKeyType expands a key type into the signature algorithms that use it
+func algorithmsForKeyType(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	case ssh.CertAlgoRSAv01:
+		return []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01}
+	default:
+		return []string{keyType}
+	}
+}
+
+// probeKey is a placeholder key used to list the keys on record for a host
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "netback-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("netback-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// writeKnownHosts writes lines to a known_hosts file and returns its path
+func writeKnownHosts(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKnownHostsTOFU(t *testing.T) {
+	signer, _ := sshServerKey(t)
+	key := signer.PublicKey()
+	other, _ := sshServerKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}
+
+	// The directory does not exist yet
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	k, err := NewKnownHosts(path, config.HostKeyPolicyTOFU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := k.Callback(discardLogger, &config.HostKeyConfig{})
+
+	if err := cb("r1.example:2222", remote, key); err != nil {
+		t.Fatalf("first connection: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := knownhosts.Line([]string{"r1.example:2222"}, key) + "\n"; string(data) != want {
+		t.Errorf("known_hosts = %q, want %q", data, want)
+	}
+
+	// The key on record is used from then on
+	if err := cb("r1.example:2222", remote, key); err != nil {
+		t.Errorf("same key: %v", err)
+	}
+	var hkErr *HostKeyError
+	if err := cb("r1.example:2222", remote, other.PublicKey()); !errors.As(err, &hkErr) ||
+		!slices.Equal(hkErr.Known, []string{ssh.FingerprintSHA256(key)}) {
+		t.Errorf("changed key: err = %v, want a mismatch with the recorded key", err)
+	}
+
+	// A later run reads the appended key
+	strict, err := NewKnownHosts(path, config.HostKeyPolicyStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strict.Callback(discardLogger, &config.HostKeyConfig{})("r1.example:2222", remote, key); err != nil {
+		t.Errorf("reloaded: %v", err)
+	}
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	signer, pinned := sshServerKey(t)
+	key := signer.PublicKey()
+	revoked, _ := sshServerKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	path := writeKnownHosts(t,
+		knownhosts.Line([]string{knownhosts.HashHostname("r1.example")}, key),
+		"@revoked * "+strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revoked.PublicKey()))),
+	)
+
+	tests := []struct {
+		name   string
+		policy string
+		hk     config.HostKeyConfig
+		host   string
+		key    ssh.PublicKey
+		// want is the HostKeyError expected; nil accepts the key
+		want *HostKeyError
+	}{
+		{
+			name:   "hashed entry",
+			policy: config.HostKeyPolicyStrict,
+			host:   "r1.example:22",
+			key:    key,
+		},
+		{
+			name:   "hashed entry mismatch",
+			policy: config.HostKeyPolicyStrict,
+			host:   "r1.example:22",
+			key:    otherKey(t),
+			want:   &HostKeyError{Known: []string{ssh.FingerprintSHA256(key)}},
+		},
+		{
+			name:   "unknown host",
+			policy: config.HostKeyPolicyStrict,
+			host:   "r2.example:22",
+			key:    key,
+			want:   &HostKeyError{},
+		},
+		{
+			name:   "revoked",
+			policy: config.HostKeyPolicyTOFU,
+			host:   "r2.example:22",
+			key:    revoked.PublicKey(),
+			want:   &HostKeyError{Revoked: true},
+		},
+		{
+			name:   "insecure",
+			policy: config.HostKeyPolicyInsecure,
+			host:   "r2.example:22",
+			key:    key,
+		},
+		{
+			name:   "device policy overrides",
+			policy: config.HostKeyPolicyStrict,
+			hk:     config.HostKeyConfig{HostKeyPolicy: config.HostKeyPolicyInsecure},
+			host:   "r2.example:22",
+			key:    key,
+		},
+		{
+			name:   "pinned key",
+			policy: config.HostKeyPolicyStrict,
+			hk:     config.HostKeyConfig{HostKey: pinned},
+			host:   "r2.example:22",
+			key:    key,
+		},
+		{
+			name:   "pinned key mismatch",
+			policy: config.HostKeyPolicyInsecure,
+			hk:     config.HostKeyConfig{HostKey: pinned},
+			host:   "r1.example:22",
+			key:    revoked.PublicKey(),
+			want:   &HostKeyError{Known: []string{ssh.FingerprintSHA256(key)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewKnownHosts(path, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = k.Callback(discardLogger, &tt.hk)(tt.host, remote, tt.key)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("err = %v, want the key accepted", err)
+				}
+				return
+			}
+			var hkErr *HostKeyError
+			if !errors.As(err, &hkErr) {
+				t.Fatalf("err = %v, want a HostKeyError", err)
+			}
+			if hkErr.Fingerprint != ssh.FingerprintSHA256(tt.key) || hkErr.Revoked != tt.want.Revoked ||
+				!slices.Equal(hkErr.Known, tt.want.Known) {
+				t.Errorf("err = %+v, want %+v", hkErr, tt.want)
+			}
+		})
+	}
+}
+
+// otherKey returns a public key not used elsewhere in a test
+func otherKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	signer, _ := sshServerKey(t)
+	return signer.PublicKey()
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	edSigner, pinned := sshServerKey(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeKnownHosts(t,
+		knownhosts.Line([]string{"r1.example"}, edSigner.PublicKey()),
+		knownhosts.Line([]string{knownhosts.HashHostname("r1.example")}, rsaPub),
+		knownhosts.Line([]string{"[r2.example]:2222"}, rsaPub),
+	)
+	k, err := NewKnownHosts(path, config.HostKeyPolicyStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaAlgos := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	tests := []struct {
+		name string
+		hk   config.HostKeyConfig
+		addr string
+		want []string
+	}{
+		{
+			name: "keys on record",
+			addr: "r1.example:22",
+			want: append([]string{ssh.KeyAlgoED25519}, rsaAlgos...),
+		},
+		{
+			name: "non-standard port",
+			addr: "r2.example:2222",
+			want: rsaAlgos,
+		},
+		{
+			name: "unknown host",
+			addr: "r3.example:22",
+		},
+		{
+			name: "pinned key",
+			hk:   config.HostKeyConfig{HostKey: pinned},
+			addr: "r2.example:2222",
+			want: []string{ssh.KeyAlgoED25519},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := k.HostKeyAlgorithms(&tt.hk, tt.addr)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("HostKeyAlgorithms = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/zinrai/netback/config"
+)
+
+// newHTTPClient creates an HTTP client for the device, dialing through the
+// Dialer so that proxies and jump hosts apply, and returns the base URL of the device
+func newHTTPClient(device *config.Device, opts *Options) (*http.Client, string, error) {
+	scheme := "https"
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: device.TLS.Insecure,
+	}
+
+	if device.TLS.Disable {
+		scheme = "http"
+	} else if device.TLS.CA != "" {
+		pem, err := os.ReadFile(expandHome(device.TLS.CA))
+		if err != nil {
+			return nil, "", fmt.Errorf("read ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, "", fmt.Errorf("no certificates found in %s", device.TLS.CA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	httpTransport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return opts.Dialer.Dial(ctx, opts.logger(), device, addr)
+		},
+		TLSHandshakeTimeout: device.EffectiveTimeout(),
+	}
+
+	// Requests are bounded by their context, so that commands can have
+	// their own timeout
+	client := &http.Client{Transport: httpTransport}
+
+	host := net.JoinHostPort(device.IP, strconv.Itoa(device.EffectivePort()))
+	return client, scheme + "://" + host, nil
+}
@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zinrai/netback/config"
+)
+
+func init() {
+	Register(config.TransportHTTPJSONRPC, func(device *config.Device, model *config.Model, opts *Options) Transport {
+		return NewJSONRPCClient(device, model, opts)
+	})
+}
+
+// JSONRPCClient runs commands through a JSON-RPC command API such as Arista eAPI
+type JSONRPCClient struct {
+	device *config.Device
+	model  *config.Model
+	opts   *Options
+	client *http.Client
+	url    string
+	id     int
+}
+
+// jsonrpcRequest is a runCmds request
+type jsonrpcRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  jsonrpcParams `json:"params"`
+	ID      string        `json:"id"`
+}
+
+type jsonrpcParams struct {
+	Version int    `json:"version"`
+	Cmds    []any  `json:"cmds"`
+	Format  string `json:"format"`
+}
+
+// jsonrpcCommand is a command that answers a prompt (e.g. enable with a password)
+type jsonrpcCommand struct {
+	Cmd   string `json:"cmd"`
+	Input string `json:"input"`
+}
+
+type jsonrpcResponse struct {
+	Result []struct {
+		Output string `json:"output"`
+	} `json:"result"`
+	Error *struct {
+		Code    int               `json:"code"`
+		Message string            `json:"message"`
+		Data    []json.RawMessage `json:"data"`
+	} `json:"error"`
+}
+
+// NewJSONRPCClient creates a new JSON-RPC client for the device
+func NewJSONRPCClient(device *config.Device, model *config.Model, opts *Options) *JSONRPCClient {
+	return &JSONRPCClient{
+		device: device,
+		model:  model,
+		opts:   opts,
+	}
+}
+
+// Connect prepares the HTTP client; the API is stateless so nothing is sent yet
+func (c *JSONRPCClient) Connect(ctx context.Context) error {
+	c.opts.logger().Info("connecting", "phase", PhaseConnect)
+
+	client, baseURL, err := newHTTPClient(c.device, c.opts)
+	if err != nil {
+		return err
+	}
+	c.client = client
+	c.url = baseURL + c.model.JSONRPC.EffectivePath()
+
+	return nil
+}
+
+// Run sends the post_login commands followed by cmd and returns the text output of cmd
+func (c *JSONRPCClient) Run(ctx context.Context, cmd *config.Command) (string, error) {
+	if c.client == nil {
+		return "", fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cmd.EffectiveTimeout(c.device.EffectiveTimeout()))
+	defer cancel()
+
+	// The API shows no prompt to match login.enable_prompt against, so when
+	// it is set every post_login command carries the enable_password, which
+	// the API passes to a command only when it asks for a password
+	answerEnable := c.model.Login.EnablePrompt != "" && c.device.EnablePassword != ""
+
+	cmds := make([]any, 0, len(c.model.Connection.PostLogin)+1)
+	for _, pre := range c.model.Connection.PostLogin {
+		if answerEnable {
+			cmds = append(cmds, jsonrpcCommand{Cmd: pre, Input: c.device.EnablePassword})
+		} else {
+			cmds = append(cmds, pre)
+		}
+	}
+	cmds = append(cmds, cmd.Cmd)
+
+	c.id++
+	body, err := json.Marshal(jsonrpcRequest{
+		JSONRPC: "2.0",
+		Method:  "runCmds",
+		Params:  jsonrpcParams{Version: 1, Cmds: cmds, Format: "text"},
+		ID:      c.device.Name + "-" + strconv.Itoa(c.id),
+	})
+	if err != nil {
+		return "", fmt.Errorf("encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.device.Username, c.device.Password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("read response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", &AuthError{Phase: PhaseConnect, Err: fmt.Errorf("authentication failed: %s", resp.Status)}
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var rpcResp jsonrpcResponse
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+
+	if rpcResp.Error != nil {
+		return "", fmt.Errorf("jsonrpc error %d: %s%s",
+			rpcResp.Error.Code, rpcResp.Error.Message, jsonrpcErrorDetails(rpcResp.Error.Data))
+	}
+
+	if len(rpcResp.Result) != len(cmds) {
+		return "", fmt.Errorf("expected %d results, got %d", len(cmds), len(rpcResp.Result))
+	}
+
+	return rpcResp.Result[len(cmds)-1].Output, nil
+}
+
+// jsonrpcErrorDetails collects the per-command error messages of an eAPI error
+func jsonrpcErrorDetails(data []json.RawMessage) string {
+	var details []string
+	for _, raw := range data {
+		var d struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(raw, &d) == nil {
+			details = append(details, d.Errors...)
+		}
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(details, "; ") + ")"
+}
+
+// Framed reports false: the API returns bare command output
+func (c *JSONRPCClient) Framed() bool {
+	return false
+}
+
+// Close releases idle HTTP connections
+func (c *JSONRPCClient) Close() error {
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+		c.client = nil
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+)
+
+// discardLogger drops the transports' log messages in tests
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// newJSONRPCTest starts an eAPI stand-in answering with handler and returns
+// a connected client for it
+func newJSONRPCTest(t *testing.T, model *config.Model, device *config.Device, handler http.HandlerFunc) *JSONRPCClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Name = "sw1"
+	device.IP = host
+	device.Port, _ = strconv.Atoi(port)
+	device.Username = "admin"
+	device.Password = "secret"
+	device.TLS.Disable = true
+
+	c := NewJSONRPCClient(device, model, &Options{Dialer: NewDialer(nil, nil), Logger: discardLogger})
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// runCmdsRequest is a runCmds request as received, with each command as
+// raw JSON since it is either a string or an object
+type runCmdsRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	ID      string `json:"id"`
+	Params  struct {
+		Version int               `json:"version"`
+		Cmds    []json.RawMessage `json:"cmds"`
+		Format  string            `json:"format"`
+	} `json:"params"`
+}
+
+// decodeRunCmds decodes a runCmds request, failing the test on anything unexpected
+func decodeRunCmds(t *testing.T, r *http.Request) runCmdsRequest {
+	t.Helper()
+	if r.Method != http.MethodPost {
+		t.Errorf("method = %s, want POST", r.Method)
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var req runCmdsRequest
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("decode request %s: %v", body, err)
+	}
+	return req
+}
+
+func TestJSONRPCRun(t *testing.T) {
+	model := &config.Model{
+		JSONRPC:    config.JSONRPCConfig{Path: "/api"},
+		Login:      config.LoginConfig{EnablePrompt: `Password:`},
+		Connection: config.ConnectionConfig{PostLogin: []string{"enable", "terminal width 200"}},
+	}
+	device := &config.Device{EnablePassword: "en-secret"}
+
+	var got runCmdsRequest
+	c := newJSONRPCTest(t, model, device, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api" {
+			t.Errorf("path = %s, want /api", r.URL.Path)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+			t.Errorf("basic auth = %q %q %v", user, pass, ok)
+		}
+		got = decodeRunCmds(t, r)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":"sw1-1","result":[{},{"output":""},{"output":"hostname sw1\n"}]}`))
+	})
+
+	out, err := c.Run(context.Background(), &config.Command{Cmd: "show running-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hostname sw1\n" {
+		t.Errorf("output = %q", out)
+	}
+
+	if got.JSONRPC != "2.0" || got.Method != "runCmds" || got.ID != "sw1-1" {
+		t.Errorf("request = %+v", got)
+	}
+	if got.Params.Version != 1 || got.Params.Format != "text" {
+		t.Errorf("params = %+v", got.Params)
+	}
+	wantCmds := []string{
+		`{"cmd":"enable","input":"en-secret"}`,
+		`{"cmd":"terminal width 200","input":"en-secret"}`,
+		`"show running-config"`,
+	}
+	if len(got.Params.Cmds) != len(wantCmds) {
+		t.Fatalf("cmds = %s, want %s", got.Params.Cmds, wantCmds)
+	}
+	for i := range wantCmds {
+		if string(got.Params.Cmds[i]) != wantCmds[i] {
+			t.Errorf("cmds[%d] = %s, want %s", i, got.Params.Cmds[i], wantCmds[i])
+		}
+	}
+}
+
+func TestJSONRPCPlainPostLogin(t *testing.T) {
+	tests := []struct {
+		name   string
+		model  *config.Model
+		device *config.Device
+	}{
+		{
+			name: "no enable_password",
+			model: &config.Model{
+				Login:      config.LoginConfig{EnablePrompt: `Password:`},
+				Connection: config.ConnectionConfig{PostLogin: []string{"enable"}},
+			},
+			device: &config.Device{},
+		},
+		{
+			name:   "no enable_prompt",
+			model:  &config.Model{Connection: config.ConnectionConfig{PostLogin: []string{"enable"}}},
+			device: &config.Device{EnablePassword: "en-secret"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got runCmdsRequest
+			c := newJSONRPCTest(t, tt.model, tt.device, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != config.DefaultJSONRPCPath {
+					t.Errorf("path = %s, want %s", r.URL.Path, config.DefaultJSONRPCPath)
+				}
+				got = decodeRunCmds(t, r)
+				w.Write([]byte(`{"result":[{},{"output":"ok"}]}`))
+			})
+
+			if _, err := c.Run(context.Background(), &config.Command{Cmd: "show version"}); err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Params.Cmds) != 2 || string(got.Params.Cmds[0]) != `"enable"` {
+				t.Errorf("cmds = %s, want a plain enable first", got.Params.Cmds)
+			}
+		})
+	}
+}
+
+func TestJSONRPCErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		err    string
+		auth   bool
+	}{
+		{
+			name:   "eapi error",
+			status: http.StatusOK,
+			body: `{"jsonrpc":"2.0","id":"sw1-1","error":{"code":1002,` +
+				`"message":"CLI command 1 of 1 'show bogus' failed: invalid command",` +
+				`"data":[{"errors":["Invalid input (at token 1: 'bogus')"]}]}}`,
+			err: "jsonrpc error 1002: CLI command 1 of 1 'show bogus' failed: invalid command (Invalid input (at token 1: 'bogus'))",
+		},
+		{
+			name:   "unauthorized",
+			status: http.StatusUnauthorized,
+			body:   `Unauthorized`,
+			err:    "authentication failed: 401 Unauthorized",
+			auth:   true,
+		},
+		{
+			name:   "server error",
+			status: http.StatusInternalServerError,
+			body:   `{}`,
+			err:    "unexpected status 500 Internal Server Error",
+		},
+		{
+			name:   "not json",
+			status: http.StatusOK,
+			body:   `<html>login</html>`,
+			err:    "decode response",
+		},
+		{
+			name:   "missing results",
+			status: http.StatusOK,
+			body:   `{"result":[]}`,
+			err:    "expected 1 results, got 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newJSONRPCTest(t, &config.Model{}, &config.Device{}, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			_, err := c.Run(context.Background(), &config.Command{Cmd: "show bogus"})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			var authErr *AuthError
+			if errors.As(err, &authErr) != tt.auth {
+				t.Errorf("AuthError = %v, want %v", !tt.auth, tt.auth)
+			}
+			if tt.auth && authErr.Phase != PhaseConnect {
+				t.Errorf("phase = %q, want %q", authErr.Phase, PhaseConnect)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// NETCONF capabilities (RFC 6241)
+const (
+	netconfBase10    = "urn:ietf:params:netconf:base:1.0"
+	netconfBase11    = "urn:ietf:params:netconf:base:1.1"
+	netconfCandidate = "urn:ietf:params:netconf:capability:candidate:1.0"
+	netconfStartup   = "urn:ietf:params:netconf:capability:startup:1.0"
+	netconfNamespace = "urn:ietf:params:xml:ns:netconf:base:1.0"
+)
+
+// netconfEOM terminates messages in NETCONF 1.0 framing (RFC 6242 section 4.3)
+const netconfEOM = "]]>]]>"
+
+func init() {
+	Register(config.TransportNetconf, func(device *config.Device, model *config.Model, opts *Options) Transport {
+		return NewNetconfClient(device, model, opts)
+	})
+}
+
+// NetconfClient retrieves configuration over the NETCONF SSH subsystem
+type NetconfClient struct {
+	device       *config.Device
+	model        *config.Model
+	opts         *Options
+	client       *ssh.Client
+	session      *ssh.Session
+	stdin        io.Writer
+	stdout       *bufio.Reader
+	chunked      bool
+	capabilities []string
+	messageID    int
+}
+
+// NewNetconfClient creates a new NETCONF client for the device
+func NewNetconfClient(device *config.Device, model *config.Model, opts *Options) *NetconfClient {
+	return &NetconfClient{
+		device: device,
+		model:  model,
+		opts:   opts,
+	}
+}
+
+// Connect opens the netconf subsystem and exchanges hello messages
+func (c *NetconfClient) Connect(ctx context.Context) error {
+	client, err := dialSSH(ctx, c.device, c.model, c.opts)
+	if err != nil {
+		return err
+	}
+	c.client = client
+
+	c.session, err = c.client.NewSession()
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("new session: %w", err)
+	}
+
+	stdin, err := c.session.StdinPipe()
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("stdin pipe: %w", err)
+	}
+	c.stdin = stdin
+
+	stdout, err := c.session.StdoutPipe()
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("stdout pipe: %w", err)
+	}
+	c.stdout = bufio.NewReader(stdout)
+
+	if err := c.session.RequestSubsystem("netconf"); err != nil {
+		c.Close()
+		return fmt.Errorf("request netconf subsystem: %w", err)
+	}
+
+	c.opts.logger().Debug("exchanging hello", "phase", PhaseConnect)
+	if err := c.hello(ctx); err != nil {
+		c.Close()
+		return fmt.Errorf("hello: %w", err)
+	}
+
+	return nil
+}
+
+// hello sends our capabilities, reads the server's and selects the framing
+func (c *NetconfClient) hello(ctx context.Context) error {
+	hello := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<hello xmlns="` + netconfNamespace + `"><capabilities>` +
+		`<capability>` + netconfBase10 + `</capability>` +
+		`<capability>` + netconfBase11 + `</capability>` +
+		`</capabilities></hello>`
+
+	// Hello messages always use end-of-message framing
+	data, err := c.exchange(ctx, []byte(hello), c.device.EffectiveTimeout())
+	if err != nil {
+		return err
+	}
+
+	var reply struct {
+		Capabilities []string `xml:"capabilities>capability"`
+	}
+	if err := xml.Unmarshal(data, &reply); err != nil {
+		return fmt.Errorf("parse server hello: %w", err)
+	}
+
+	c.capabilities = make([]string, len(reply.Capabilities))
+	for i, capability := range reply.Capabilities {
+		c.capabilities[i] = strings.TrimSpace(capability)
+	}
+
+	switch {
+	case c.hasCapability(netconfBase11):
+		c.chunked = true
+	case c.hasCapability(netconfBase10):
+	default:
+		return fmt.Errorf("server supports neither base:1.0 nor base:1.1")
+	}
+
+	return nil
+}
+
+// hasCapability reports whether the server advertised the capability,
+// ignoring any query parameters
+func (c *NetconfClient) hasCapability(capability string) bool {
+	for _, have := range c.capabilities {
+		if have == capability || strings.HasPrefix(have, capability+"?") {
+			return true
+		}
+	}
+	return false
+}
+
+// Run retrieves a datastore (running, candidate, startup) with <get-config>,
+// or sends cmd as the RPC body if it starts with "<"
+func (c *NetconfClient) Run(ctx context.Context, command *config.Command) (string, error) {
+	if c.session == nil {
+		return "", fmt.Errorf("not connected")
+	}
+
+	cmd := command.Cmd
+	var body string
+	if strings.HasPrefix(strings.TrimSpace(cmd), "<") {
+		body = cmd
+	} else {
+		switch cmd {
+		case "running":
+		case "candidate":
+			if !c.hasCapability(netconfCandidate) {
+				return "", fmt.Errorf("device does not support the candidate datastore")
+			}
+		case "startup":
+			if !c.hasCapability(netconfStartup) {
+				return "", fmt.Errorf("device does not support the startup datastore")
+			}
+		default:
+			return "", fmt.Errorf("unknown datastore %q", cmd)
+		}
+		body = "<get-config><source><" + cmd + "/></source></get-config>"
+	}
+
+	data, err := c.rpc(ctx, body, command.EffectiveTimeout(c.device.EffectiveTimeout()))
+	if err != nil {
+		return "", err
+	}
+
+	return formatXML(data, c.model.Netconf.Format)
+}
+
+// Framed reports false: replies carry no command echo or prompt
+func (c *NetconfClient) Framed() bool {
+	return false
+}
+
+// rpc sends an RPC and returns the contents of the <data> element of the reply
+// (or the whole reply if it has none), waiting at most timeout. An exchange
+// that fails closes the connection, so its error is a SessionLost.
+func (c *NetconfClient) rpc(ctx context.Context, body string, timeout time.Duration) ([]byte, error) {
+	c.messageID++
+	msg := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<rpc message-id="` + strconv.Itoa(c.messageID) + `" xmlns="` + netconfNamespace + `">` +
+		body + `</rpc>`
+
+	reply, err := c.exchange(ctx, []byte(msg), timeout)
+	if err != nil {
+		return nil, &SessionLost{Err: err}
+	}
+
+	data, warnings, err := parseRPCReply(reply)
+	for _, w := range warnings {
+		c.opts.logger().Warn("rpc-reply warning", "phase", PhaseCommand, "warning", w)
+	}
+	return data, err
+}
+
+// exchange sends a message and reads the reply within timeout. On timeout
+// the connection is closed to unblock the read.
+func (c *NetconfClient) exchange(ctx context.Context, msg []byte, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stop := closeOnDone(ctx, c.client, "waiting for reply")
+
+	err := c.writeMessage(msg)
+	var reply []byte
+	if err == nil {
+		reply, err = c.readMessage()
+	}
+	if ctxErr := stop(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return reply, err
+}
+
+// rpcError is an <rpc-error> of an rpc-reply
+type rpcError struct {
+	severity string
+	message  string
+}
+
+func (e *rpcError) String() string {
+	if e.message == "" {
+		return "unspecified " + e.severity
+	}
+	return e.message
+}
+
+// parseRPCReply extracts the <data> contents of an rpc-reply. It fails on an
+// <rpc-error> of severity error and returns the messages of those of
+// severity warning, which servers such as Junos send with successful replies.
+func parseRPCReply(reply []byte) ([]byte, []string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(reply))
+
+	var (
+		depth     int
+		dataStart int64 = -1
+		dataEnd   int64 = -1
+		errs      []rpcError
+		// field is the rpc-error child whose text is being read
+		field string
+	)
+
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse rpc-reply: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 2 && t.Name.Local == "data" && dataStart < 0:
+				dataStart = dec.InputOffset()
+			case depth == 2 && t.Name.Local == "rpc-error":
+				// Severity defaults to error if the server leaves it out
+				errs = append(errs, rpcError{severity: "error"})
+			case depth == 3 && len(errs) > 0 && (t.Name.Local == "error-severity" || t.Name.Local == "error-message"):
+				field = t.Name.Local
+			}
+		case xml.EndElement:
+			switch {
+			case depth == 2 && t.Name.Local == "data" && dataEnd < 0:
+				dataEnd = offset
+			case depth == 3:
+				field = ""
+			}
+			depth--
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			switch field {
+			case "error-severity":
+				errs[len(errs)-1].severity = text
+			case "error-message":
+				errs[len(errs)-1].message += text
+			}
+		}
+	}
+
+	var failures, warnings []string
+	for i := range errs {
+		if errs[i].severity == "warning" {
+			warnings = append(warnings, errs[i].String())
+		} else {
+			failures = append(failures, errs[i].String())
+		}
+	}
+	if len(failures) > 0 {
+		return nil, warnings, fmt.Errorf("rpc-error: %s", strings.Join(failures, "; "))
+	}
+	if dataStart >= 0 && dataEnd >= 0 {
+		return reply[dataStart:dataEnd], warnings, nil
+	}
+	return reply, warnings, nil
+}
+
+// writeMessage frames and sends a message
+func (c *NetconfClient) writeMessage(msg []byte) error {
+	var err error
+	if c.chunked {
+		_, err = fmt.Fprintf(c.stdin, "\n#%d\n%s\n##\n", len(msg), msg)
+	} else {
+		_, err = fmt.Fprintf(c.stdin, "%s%s", msg, netconfEOM)
+	}
+	if err != nil {
+		return fmt.Errorf("send message: %w", err)
+	}
+	return nil
+}
+
+// readMessage reads one framed message
+func (c *NetconfClient) readMessage() ([]byte, error) {
+	if c.chunked {
+		return c.readChunked()
+	}
+	return c.readEOM()
+}
+
+// readEOM reads a message terminated by ]]>]]>
+func (c *NetconfClient) readEOM() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		b, err := c.stdout.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read message: %w", err)
+		}
+		buf.WriteByte(b)
+		if b == '>' && bytes.HasSuffix(buf.Bytes(), []byte(netconfEOM)) {
+			return buf.Bytes()[:buf.Len()-len(netconfEOM)], nil
+		}
+	}
+}
+
+// readChunked reads a message in chunked framing (RFC 6242 section 4.2)
+func (c *NetconfClient) readChunked() ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		// Each chunk header is LF # <size> LF; the message ends with LF ## LF
+		header, err := c.stdout.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read chunk header: %w", err)
+		}
+		if header == "\n" {
+			header, err = c.stdout.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("read chunk header: %w", err)
+			}
+		}
+		header = strings.TrimSuffix(header, "\n")
+
+		if header == "##" {
+			return buf.Bytes(), nil
+		}
+		if !strings.HasPrefix(header, "#") {
+			return nil, fmt.Errorf("invalid chunk header %q", header)
+		}
+		size, err := strconv.ParseUint(header[1:], 10, 32)
+		if err != nil || size == 0 {
+			return nil, fmt.Errorf("invalid chunk size %q", header[1:])
+		}
+		if _, err := io.CopyN(&buf, c.stdout, int64(size)); err != nil {
+			return nil, fmt.Errorf("read chunk: %w", err)
+		}
+	}
+}
+
+// Close ends the NETCONF session and the SSH connection
+func (c *NetconfClient) Close() error {
+	if c.stdin != nil {
+		// Best effort; the reply is not awaited
+		c.messageID++
+		_ = c.writeMessage([]byte(`<rpc message-id="` + strconv.Itoa(c.messageID) +
+			`" xmlns="` + netconfNamespace + `"><close-session/></rpc>`))
+		c.stdin = nil
+	}
+
+	var errs []error
+
+	if c.session != nil {
+		if err := c.session.Close(); err != nil && err != io.EOF {
+			errs = append(errs, err)
+		}
+		c.session = nil
+	}
+
+	if c.client != nil {
+		if err := c.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		c.client = nil
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("close errors: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+)
+
+func TestParseRPCReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		want     string
+		warnings []string
+		err      string
+	}{
+		{
+			name:  "data",
+			reply: `<rpc-reply message-id="1"><data><system><host-name>r1</host-name></system></data></rpc-reply>`,
+			want:  `<system><host-name>r1</host-name></system>`,
+		},
+		{
+			name:  "empty data",
+			reply: `<rpc-reply message-id="1"><data/></rpc-reply>`,
+			want:  ``,
+		},
+		{
+			name:  "no data",
+			reply: `<rpc-reply message-id="1"><ok/></rpc-reply>`,
+			want:  `<rpc-reply message-id="1"><ok/></rpc-reply>`,
+		},
+		{
+			name: "warning",
+			reply: `<rpc-reply message-id="1">` +
+				`<rpc-error><error-severity>warning</error-severity><error-message>statement not supported</error-message></rpc-error>` +
+				`<data><system/></data></rpc-reply>`,
+			want:     `<system/>`,
+			warnings: []string{"statement not supported"},
+		},
+		{
+			name: "warning after data",
+			reply: `<rpc-reply message-id="1"><data><system/></data>` +
+				`<rpc-error><error-severity>warning</error-severity></rpc-error></rpc-reply>`,
+			want:     `<system/>`,
+			warnings: []string{"unspecified warning"},
+		},
+		{
+			name: "error",
+			reply: `<rpc-reply message-id="1"><rpc-error>` +
+				`<error-type>protocol</error-type><error-severity>error</error-severity>` +
+				`<error-message>permission denied</error-message></rpc-error></rpc-reply>`,
+			err: "rpc-error: permission denied",
+		},
+		{
+			name:  "error without severity",
+			reply: `<rpc-reply message-id="1"><rpc-error><error-message>bad</error-message></rpc-error></rpc-reply>`,
+			err:   "rpc-error: bad",
+		},
+		{
+			name: "error and warning",
+			reply: `<rpc-reply message-id="1">` +
+				`<rpc-error><error-severity>warning</error-severity><error-message>deprecated</error-message></rpc-error>` +
+				`<rpc-error><error-severity>error</error-severity></rpc-error>` +
+				`<data><system/></data></rpc-reply>`,
+			warnings: []string{"deprecated"},
+			err:      "rpc-error: unspecified error",
+		},
+		{
+			name:  "malformed",
+			reply: `<rpc-reply><data>`,
+			err:   "parse rpc-reply",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, warnings, err := parseRPCReply([]byte(tt.reply))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(data) != tt.want {
+					t.Errorf("data = %q, want %q", data, tt.want)
+				}
+			}
+			if strings.Join(warnings, "|") != strings.Join(tt.warnings, "|") {
+				t.Errorf("warnings = %q, want %q", warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestReadEOM(t *testing.T) {
+	c := &NetconfClient{stdout: bufio.NewReader(strings.NewReader("<hello/>]]>]]><rpc-reply>]]</rpc-reply>]]>]]>\n<trunc"))}
+
+	for _, want := range []string{"<hello/>", "<rpc-reply>]]</rpc-reply>"} {
+		msg, err := c.readEOM()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg) != want {
+			t.Errorf("message = %q, want %q", msg, want)
+		}
+	}
+
+	if _, err := c.readEOM(); err == nil {
+		t.Error("expected an error for a message without ]]>]]>")
+	}
+}
+
+func TestReadChunked(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   string
+	}{
+		{name: "single chunk", input: "\n#8\n<reply/>\n##\n", want: "<reply/>"},
+		{name: "multiple chunks", input: "\n#4\n<rep\n#4\nly/>\n##\n", want: "<reply/>"},
+		{name: "chunk containing newlines", input: "\n#5\na\n#\nb\n##\n", want: "a\n#\nb"},
+		{name: "missing header", input: "\n<reply/>\n##\n", err: "invalid chunk header"},
+		{name: "zero size", input: "\n#0\n\n##\n", err: "invalid chunk size"},
+		{name: "non-numeric size", input: "\n#x\n", err: "invalid chunk size"},
+		{name: "oversize", input: "\n#99999999999\n", err: "invalid chunk size"},
+		{name: "truncated chunk", input: "\n#100\n<reply/>", err: "read chunk"},
+		{name: "truncated header", input: "\n#8", err: "read chunk header"},
+		{name: "missing end of chunks", input: "\n#8\n<reply/>", err: "read chunk header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &NetconfClient{stdout: bufio.NewReader(strings.NewReader(tt.input))}
+			msg, err := c.readChunked()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(msg) != tt.want {
+				t.Errorf("message = %q, want %q", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	var buf bytes.Buffer
+	c := &NetconfClient{stdin: &buf}
+
+	if err := c.writeMessage([]byte("<rpc/>")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "<rpc/>]]>]]>"; got != want {
+		t.Errorf("end-of-message framing = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	c.chunked = true
+	if err := c.writeMessage([]byte("<rpc/>")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "\n#6\n<rpc/>\n##\n"; got != want {
+		t.Errorf("chunked framing = %q, want %q", got, want)
+	}
+
+	// What is written in chunked framing reads back unchanged
+	c.stdout = bufio.NewReader(&buf)
+	msg, err := c.readChunked()
+	if err != nil || string(msg) != "<rpc/>" {
+		t.Errorf("read back %q, %v", msg, err)
+	}
+}
+
+func TestFormatXML(t *testing.T) {
+	const input = `<?xml version="1.0"?>
+<configuration xmlns:junos="http://xml.juniper.net/junos" b="2" xmlns="urn:example" a="1">
+  <!-- comment -->
+  <system><host-name>r1 &amp; r2</host-name><empty/></system>
+</configuration>`
+
+	tests := []struct {
+		format string
+		want   string
+		err    string
+	}{
+		{format: "", want: input},
+		{format: config.FormatRaw, want: input},
+		{
+			format: config.FormatPretty,
+			want: `<configuration xmlns:junos="http://xml.juniper.net/junos" b="2" xmlns="urn:example" a="1">
+  <system>
+    <host-name>r1 &amp; r2</host-name>
+    <empty></empty>
+  </system>
+</configuration>
+`,
+		},
+		{
+			format: config.FormatCanonical,
+			want:   `<configuration xmlns="urn:example" xmlns:junos="http://xml.juniper.net/junos" a="1" b="2"><system><host-name>r1 &amp; r2</host-name><empty></empty></system></configuration>`,
+		},
+		{format: "yaml", err: "unknown xml format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := formatXML([]byte(input), tt.format)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+
+	for _, malformed := range []string{"<a><b></a>", "<a>", "</a>"} {
+		if _, err := formatXML([]byte(malformed), config.FormatPretty); err == nil {
+			t.Errorf("formatXML(%q): expected an error", malformed)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zinrai/netback/config"
+)
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929)
+const (
+	socksVersion        = 5
+	socksAuthNone       = 0x00
+	socksAuthPassword   = 0x02
+	socksNoAcceptable   = 0xff
+	socksCmdConnect     = 0x01
+	socksAtypIPv4       = 0x01
+	socksAtypDomain     = 0x03
+	socksAtypIPv6       = 0x04
+	socksPasswordVer    = 0x01
+	socksPasswordOK     = 0x00
+	socksReplySucceeded = 0x00
+)
+
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// dialProxy connects to addr through the proxy at u. The whole exchange,
+// including the connection to the proxy, is bounded by timeout.
+func dialProxy(ctx context.Context, u *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := closeOnDone(ctx, conn, "connecting through proxy")
+	switch u.Scheme {
+	case config.ProxyHTTP:
+		conn, err = connectHTTP(conn, u, addr)
+	default:
+		err = connectSOCKS5(ctx, conn, u, addr)
+	}
+	if ctxErr := stop(); ctxErr != nil {
+		err = ctxErr
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// connectSOCKS5 performs the SOCKS5 handshake and CONNECT request
+func connectSOCKS5(ctx context.Context, conn net.Conn, u *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// Method negotiation
+	methods := []byte{socksAuthNone}
+	if u.User != nil {
+		methods = append(methods, socksAuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("read method: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("not a socks5 proxy")
+	}
+
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if u.User == nil {
+			return &AuthError{Phase: PhaseConnect, Err: fmt.Errorf("proxy requires authentication")}
+		}
+		if err := socksPasswordAuth(conn, u.User); err != nil {
+			return err
+		}
+	case socksNoAcceptable:
+		return fmt.Errorf("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", reply[1])
+	}
+
+	// CONNECT request; socks5 resolves names locally, socks5h on the proxy
+	req := []byte{socksVersion, socksCmdConnect, 0}
+	ip := net.ParseIP(host)
+	if ip == nil && u.Scheme == config.ProxySOCKS5 {
+		ip, err = resolveIP(ctx, host)
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long")
+		}
+		req = append(req, socksAtypDomain, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, socksAtypIPv4)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, socksAtypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+	if hdr[1] != socksReplySucceeded {
+		if msg, ok := socksReplies[hdr[1]]; ok {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("request failed with code %d", hdr[1])
+	}
+
+	// Skip the bound address and port
+	var skip int
+	switch hdr[3] {
+	case socksAtypIPv4:
+		skip = net.IPv4len + 2
+	case socksAtypIPv6:
+		skip = net.IPv6len + 2
+	case socksAtypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return fmt.Errorf("read reply: %w", err)
+		}
+		skip = int(n[0]) + 2
+	default:
+		return fmt.Errorf("unknown address type %d in reply", hdr[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		return fmt.Errorf("read reply: %w", err)
+	}
+
+	return nil
+}
+
+// socksPasswordAuth performs username/password authentication (RFC 1929)
+func socksPasswordAuth(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("proxy username or password too long")
+	}
+
+	req := []byte{socksPasswordVer, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return fmt.Errorf("read auth reply: %w", err)
+	}
+	if reply[1] != socksPasswordOK {
+		return &AuthError{Phase: PhaseConnect, Err: fmt.Errorf("proxy authentication failed")}
+	}
+	return nil
+}
+
+// resolveIP looks up host, preferring IPv4
+func resolveIP(ctx context.Context, host string) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			return a.IP, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+	return addrs[0].IP, nil
+}
+
+// connectHTTP opens a tunnel with an HTTP CONNECT request
+func connectHTTP(conn net.Conn, u *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		token := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+token)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, fmt.Errorf("read response: %w", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return conn, &AuthError{Phase: PhaseConnect, Err: fmt.Errorf("proxy authentication failed: %s", resp.Status)}
+	case resp.StatusCode != http.StatusOK:
+		return conn, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// Keep anything the proxy sent after its response
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were read ahead
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,286 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyStub accepts a single connection and hands it to serve. It returns
+// the proxy URL for scheme, with userinfo if given.
+func proxyStub(t *testing.T, scheme string, user *url.Userinfo, serve func(conn net.Conn)) *url.URL {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+
+	return &url.URL{Scheme: scheme, User: user, Host: ln.Addr().String()}
+}
+
+// expect reads len(want) bytes and reports a mismatch
+func expect(t *testing.T, r io.Reader, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Errorf("read %q: %v", want, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("proxy received %q, want %q", got, want)
+	}
+}
+
+// readTunnel reads what the stub sent through the tunnel
+func readTunnel(t *testing.T, conn net.Conn, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != want {
+		t.Errorf("tunnel read %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	tests := []struct {
+		name   string
+		scheme string
+		user   *url.Userinfo
+		addr   string
+		// greeting and request are what the client must send
+		greeting []byte
+		request  []byte
+		// bound is the address part of the proxy's reply
+		bound []byte
+	}{
+		{
+			name:     "ipv4",
+			scheme:   "socks5",
+			addr:     "192.0.2.1:22",
+			greeting: []byte{5, 1, 0},
+			request:  []byte{5, 1, 0, 1, 192, 0, 2, 1, 0, 22},
+			bound:    []byte{1, 10, 0, 0, 1, 0x12, 0x34},
+		},
+		{
+			name:     "ipv6",
+			scheme:   "socks5",
+			addr:     "[2001:db8::1]:830",
+			greeting: []byte{5, 1, 0},
+			request:  []byte{5, 1, 0, 4, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x03, 0x3e},
+			bound:    append(append([]byte{4}, net.ParseIP("2001:db8::2")...), 0, 1),
+		},
+		{
+			name:     "domain resolved on the proxy",
+			scheme:   "socks5h",
+			addr:     "router.example:22",
+			greeting: []byte{5, 1, 0},
+			request:  append(append([]byte{5, 1, 0, 3, 14}, "router.example"...), 0, 22),
+			bound:    append(append([]byte{3, 5}, "proxy"...), 0, 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := proxyStub(t, tt.scheme, tt.user, func(conn net.Conn) {
+				expect(t, conn, tt.greeting)
+				conn.Write([]byte{5, 0})
+				expect(t, conn, tt.request)
+				conn.Write(append([]byte{5, 0, 0}, tt.bound...))
+				conn.Write([]byte("SSH-2.0-device\r\n"))
+			})
+
+			conn, err := dialProxy(context.Background(), u, tt.addr, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			readTunnel(t, conn, "SSH-2.0-device\r\n")
+		})
+	}
+}
+
+func TestSOCKS5PasswordAuth(t *testing.T) {
+	u := proxyStub(t, "socks5", url.UserPassword("backup", "pw"), func(conn net.Conn) {
+		expect(t, conn, []byte{5, 2, 0, 2})
+		conn.Write([]byte{5, 2})
+		expect(t, conn, append(append([]byte{1, 6}, "backup"...), append([]byte{2}, "pw"...)...))
+		conn.Write([]byte{1, 0})
+		expect(t, conn, []byte{5, 1, 0, 1, 192, 0, 2, 1, 0, 22})
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	})
+
+	conn, err := dialProxy(context.Background(), u, "192.0.2.1:22", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestSOCKS5Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		user *url.Userinfo
+		// replies are written one after another, each after reading what
+		// the client sends
+		replies [][]byte
+		err     string
+		auth    bool
+	}{
+		{
+			name:    "password rejected",
+			user:    url.UserPassword("backup", "wrong"),
+			replies: [][]byte{{5, 2}, {1, 1}},
+			err:     "proxy authentication failed",
+			auth:    true,
+		},
+		{
+			name:    "credentials required",
+			replies: [][]byte{{5, 2}},
+			err:     "proxy requires authentication",
+			auth:    true,
+		},
+		{name: "no acceptable method", replies: [][]byte{{5, 0xff}}, err: "no acceptable authentication method"},
+		{name: "unsupported method", replies: [][]byte{{5, 1}}, err: "unsupported authentication method 1"},
+		{name: "not socks5", replies: [][]byte{{4, 0}}, err: "not a socks5 proxy"},
+		{name: "truncated method reply", replies: [][]byte{{5}}, err: "read method"},
+		{name: "connection refused", replies: [][]byte{{5, 0}, {5, 5, 0, 1}}, err: "connection refused"},
+		{name: "not allowed", replies: [][]byte{{5, 0}, {5, 2, 0, 1}}, err: "connection not allowed by ruleset"},
+		{name: "unknown reply code", replies: [][]byte{{5, 0}, {5, 42, 0, 1}}, err: "request failed with code 42"},
+		{name: "truncated reply", replies: [][]byte{{5, 0}, {5, 0, 0}}, err: "read reply"},
+		{name: "truncated bound address", replies: [][]byte{{5, 0}, {5, 0, 0, 1, 10, 0}}, err: "read reply"},
+		{name: "truncated bound domain", replies: [][]byte{{5, 0}, {5, 0, 0, 3}}, err: "read reply"},
+		{name: "unknown address type", replies: [][]byte{{5, 0}, {5, 0, 0, 9}}, err: "unknown address type 9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := proxyStub(t, "socks5", tt.user, func(conn net.Conn) {
+				buf := make([]byte, 512)
+				for _, reply := range tt.replies {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					conn.Write(reply)
+				}
+			})
+
+			_, err := dialProxy(context.Background(), u, "192.0.2.1:22", time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			var authErr *AuthError
+			if errors.As(err, &authErr) != tt.auth {
+				t.Errorf("AuthError = %v, want %v", !tt.auth, tt.auth)
+			}
+		})
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	u := proxyStub(t, "http", url.UserPassword("backup", "pw"), func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			t.Errorf("read request: %v", err)
+			return
+		}
+		if req.Method != http.MethodConnect || req.Host != "router.example:22" {
+			t.Errorf("request = %s %s", req.Method, req.Host)
+		}
+		// backup:pw
+		if got := req.Header.Get("Proxy-Authorization"); got != "Basic YmFja3VwOnB3" {
+			t.Errorf("Proxy-Authorization = %q", got)
+		}
+		// The device's banner arrives with the response
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nSSH-2.0-device\r\n"))
+	})
+
+	conn, err := dialProxy(context.Background(), u, "router.example:22", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	readTunnel(t, conn, "SSH-2.0-device\r\n")
+}
+
+func TestHTTPConnectErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		err      string
+		auth     bool
+	}{
+		{
+			name:     "auth required",
+			response: "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic\r\nContent-Length: 0\r\n\r\n",
+			err:      "proxy authentication failed: 407 Proxy Authentication Required",
+			auth:     true,
+		},
+		{
+			name:     "forbidden",
+			response: "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n",
+			err:      "unexpected status 403 Forbidden",
+		},
+		{
+			name:     "bad gateway",
+			response: "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n",
+			err:      "unexpected status 502 Bad Gateway",
+		},
+		{name: "truncated response", response: "HTTP/1.1 200", err: "read response"},
+		{name: "not http", response: "SSH-2.0-OpenSSH\r\n", err: "read response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := proxyStub(t, "http", nil, func(conn net.Conn) {
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				conn.Write([]byte(tt.response))
+			})
+
+			_, err := dialProxy(context.Background(), u, "router.example:22", time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			var authErr *AuthError
+			if errors.As(err, &authErr) != tt.auth {
+				t.Errorf("AuthError = %v, want %v", !tt.auth, tt.auth)
+			}
+		})
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	// A proxy that accepts the connection but never answers
+	u := proxyStub(t, "socks5", nil, func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	start := time.Now()
+	_, err := dialProxy(context.Background(), u, "192.0.2.1:22", 100*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s", elapsed)
+	}
+}
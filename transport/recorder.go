@@ -0,0 +1,220 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction markers of transcript lines
+const (
+	TranscriptSent     = ">"
+	TranscriptReceived = "<"
+	TranscriptNote     = "#"
+)
+
+// TranscriptTimeFormat is the timestamp format of transcript lines
+const TranscriptTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// redacted replaces passwords in transcripts
+const redacted = "<redacted>"
+
+// Recorder writes a transcript of interactive CLI sessions: every chunk
+// sent to and received from the device, on a line of its own as
+//
+//	<timestamp> > "show version\n"
+//	<timestamp> < "Cisco IOS Software...\r\nrouter#"
+//	<timestamp> # waiting for `router#\s*$` (timeout 30s)
+//
+// Chunks are quoted as Go strings, so control characters and escape
+// sequences are visible, and are recorded as read, which is what the prompt
+// and expect patterns are matched against. Passwords sent by the session,
+// and the secrets given to Redact wherever they appear in received data, are
+// recorded as <redacted>. A secret split across reads is replaced in the
+// chunk where it starts; the chunks are otherwise kept as read, so a replay
+// feeds the session the same reads. A nil Recorder records nothing.
+type Recorder struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+
+	// parent writes the transcript lines of a recorder made by Tee
+	parent *Recorder
+	// tee receives a copy of the data received from the device
+	tee io.Writer
+
+	secrets []string
+	// pending holds the chunks received last, as read, while their end
+	// could be the start of a secret continued in the next chunk
+	pending [][]byte
+}
+
+// NewRecorder creates a recorder writing to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w}
+}
+
+// Tee returns a recorder that writes its transcript lines through r, which
+// may be nil, and copies the data received from the device to w. It starts
+// with the secrets of r.
+func (r *Recorder) Tee(w io.Writer) *Recorder {
+	t := &Recorder{parent: r, tee: w}
+	if r != nil {
+		r.mu.Lock()
+		t.secrets = slices.Clone(r.secrets)
+		r.mu.Unlock()
+	}
+	return t
+}
+
+// Redact adds secrets to replace by <redacted> in data received from the
+// device. Empty strings are ignored.
+func (r *Recorder) Redact(secrets ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" && !slices.Contains(r.secrets, secret) {
+			r.secrets = append(r.secrets, secret)
+		}
+	}
+}
+
+// Sent records data sent to the device
+func (r *Recorder) Sent(data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flush()
+	r.emit(TranscriptSent, strconv.Quote(string(data)))
+}
+
+// Received records data read from the device
+func (r *Recorder) Received(data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = append(r.pending, slices.Clone(data))
+	if r.partialSecret(bytes.Join(r.pending, nil)) == 0 {
+		r.flush()
+	}
+}
+
+// Note records a comment, such as what the session is waiting for
+func (r *Recorder) Note(format string, args ...any) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flush()
+	r.emit(TranscriptNote, strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " "))
+}
+
+// Err returns the first error writing the transcript, if any
+func (r *Recorder) Err() error {
+	if r == nil {
+		return nil
+	}
+	if r.parent != nil {
+		return r.parent.Err()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// partialSecret returns the length of the longest end of buf that is the
+// start of a secret. r.mu is held.
+func (r *Recorder) partialSecret(buf []byte) int {
+	longest := 0
+	for _, secret := range r.secrets {
+		for k := min(len(secret)-1, len(buf)); k > longest; k-- {
+			if bytes.HasSuffix(buf, []byte(secret[:k])) {
+				longest = k
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// flush records the pending chunks with secrets replaced. r.mu is held.
+func (r *Recorder) flush() {
+	for _, chunk := range redactChunks(r.pending, r.secrets) {
+		if len(chunk) == 0 {
+			continue
+		}
+		r.emit(TranscriptReceived, strconv.Quote(string(chunk)))
+		if r.tee != nil {
+			r.tee.Write(chunk)
+		}
+	}
+	r.pending = nil
+}
+
+// redactChunks replaces the secrets found in chunks taken together,
+// keeping the boundaries between chunks: a secret spanning several chunks
+// is replaced in the chunk where it starts, and the rest of it is left out
+// of the chunks after that one
+func redactChunks(chunks [][]byte, secrets []string) [][]byte {
+	if len(secrets) == 0 {
+		return chunks
+	}
+	joined := bytes.Join(chunks, nil)
+	out := make([][]byte, len(chunks))
+	// chunk is the chunk joined[i] comes from, which starts at start
+	chunk, start := 0, 0
+	for i := 0; i < len(joined); {
+		for i >= start+len(chunks[chunk]) {
+			start += len(chunks[chunk])
+			chunk++
+		}
+		if n := secretAt(joined[i:], secrets); n > 0 {
+			out[chunk] = append(out[chunk], redacted...)
+			i += n
+			continue
+		}
+		out[chunk] = append(out[chunk], joined[i])
+		i++
+	}
+	return out
+}
+
+// secretAt returns the length of the longest secret data starts with, or 0
+func secretAt(data []byte, secrets []string) int {
+	n := 0
+	for _, secret := range secrets {
+		if len(secret) > n && bytes.HasPrefix(data, []byte(secret)) {
+			n = len(secret)
+		}
+	}
+	return n
+}
+
+// emit writes a transcript line, through the parent for a recorder made by
+// Tee. r.mu is held.
+func (r *Recorder) emit(marker, text string) {
+	if r.parent != nil {
+		r.parent.mu.Lock()
+		defer r.parent.mu.Unlock()
+		r.parent.emit(marker, text)
+		return
+	}
+	if r.w == nil || r.err != nil {
+		return
+	}
+	_, r.err = fmt.Fprintf(r.w, "%s %s %s\n", time.Now().Format(TranscriptTimeFormat), marker, text)
+}
@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+)
+
+func TestRecorderRedact(t *testing.T) {
+	var transcript, tail bytes.Buffer
+	parent := NewRecorder(&transcript)
+	r := parent.Tee(&tail)
+	r.Redact("s3cret!", "", "en-pw")
+
+	r.Sent([]byte("show running-config\n"))
+	// One secret split across reads, one whole, and a near miss
+	r.Received([]byte("username admin password s3c"))
+	r.Received([]byte("ret!\r\nenable secret en-pw\r\n"))
+	r.Received([]byte("description s3cr\r\nrouter#"))
+	r.Note("matched")
+	// Data still held back when the session ends is recorded by the next note
+	r.Received([]byte("exit s3"))
+	r.Note("connection closed")
+
+	if err := parent.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	wantTail := "username admin password <redacted>\r\nenable secret <redacted>\r\n" +
+		"description s3cr\r\nrouter#exit s3"
+	if tail.String() != wantTail {
+		t.Errorf("tail = %q, want %q", tail.String(), wantTail)
+	}
+
+	recorded, err := ReadTranscript(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received strings.Builder
+	var markers string
+	for _, e := range recorded.Entries {
+		markers += e.Marker
+		if e.Marker == TranscriptReceived {
+			received.WriteString(e.Data)
+		}
+	}
+	if received.String() != wantTail {
+		t.Errorf("transcript received %q, want %q", received.String(), wantTail)
+	}
+	// Each read is a chunk of its own, recorded before the note that
+	// follows it
+	if markers != "><<<#<#" {
+		t.Errorf("markers = %q, want %q", markers, "><<<#<#")
+	}
+}
+
+func TestRecorderTeeWithoutTranscript(t *testing.T) {
+	var nilRecorder *Recorder
+	var tail bytes.Buffer
+	r := nilRecorder.Tee(&tail)
+	r.Redact("pw")
+
+	r.Received([]byte("password pw\r\n"))
+	r.Note("matched")
+	if tail.String() != "password <redacted>\r\n" {
+		t.Errorf("tail = %q", tail.String())
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+}
+
+func TestRecorderReplaySplitSecret(t *testing.T) {
+	var transcript bytes.Buffer
+	r := NewRecorder(&transcript)
+	r.Redact("s3cret!")
+
+	r.Note("transcript: device=core-01 model=ios transport=ssh")
+	r.Received([]byte("router#"))
+	r.Sent([]byte("show running-config\n"))
+	// The secret is split across three reads
+	r.Received([]byte("show running-config\r\nusername admin password s3"))
+	r.Received([]byte("cr"))
+	r.Received([]byte("et!\r\nrouter#"))
+	r.Note("connection closed")
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := ReadTranscript(&transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []string
+	for _, e := range recorded.Entries {
+		if e.Marker == TranscriptReceived {
+			chunks = append(chunks, e.Data)
+		}
+	}
+	// The chunk covered by the secret is dropped; the others keep their
+	// boundaries
+	want := []string{
+		"router#",
+		"show running-config\r\nusername admin password <redacted>",
+		"\r\nrouter#",
+	}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("received chunks = %q, want %q", chunks, want)
+	}
+
+	replay := NewReplay(recorded, &config.Model{Prompt: `router#$`})
+	ctx := context.Background()
+	if err := replay.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	out, err := replay.Run(ctx, &config.Command{Cmd: "show running-config"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "show running-config\r\nusername admin password <redacted>\r\nrouter#" {
+		t.Errorf("output = %q", out)
+	}
+}
@@ -0,0 +1,244 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zinrai/netback/config"
+)
+
+// TranscriptEntry is one line of a transcript written by a Recorder
+type TranscriptEntry struct {
+	Time time.Time
+	// Marker is TranscriptSent, TranscriptReceived or TranscriptNote
+	Marker string
+	// Data is the chunk sent or received, or the text of a note
+	Data string
+}
+
+// Transcript is a recorded session read back by ReadTranscript
+type Transcript struct {
+	// Header holds the key=value fields of the first note: device, group,
+	// model and transport
+	Header  map[string]string
+	Entries []TranscriptEntry
+}
+
+// ReadTranscript parses a transcript written by a Recorder
+func ReadTranscript(r io.Reader) (*Transcript, error) {
+	t := &Transcript{Header: make(map[string]string)}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected timestamp, marker and data", n)
+		}
+		ts, err := time.Parse(TranscriptTimeFormat, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		entry := TranscriptEntry{Time: ts, Marker: fields[1]}
+		switch entry.Marker {
+		case TranscriptSent, TranscriptReceived:
+			if entry.Data, err = strconv.Unquote(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid data: %w", n, err)
+			}
+		case TranscriptNote:
+			entry.Data = fields[2]
+			if len(t.Entries) == 0 {
+				parseHeader(t.Header, entry.Data)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown marker %q", n, entry.Marker)
+		}
+		t.Entries = append(t.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// headerKeys are the fields of the header note, in the order written
+var headerKeys = []string{"device", "group", "model", "transport"}
+
+// parseHeader collects the key=value fields of the header note. Values are
+// not quoted, so a value runs up to the next known key and may hold spaces,
+// as device and group names can.
+func parseHeader(header map[string]string, note string) {
+	_, fields, ok := strings.Cut(note, "transcript: ")
+	if !ok {
+		return
+	}
+	key := ""
+	for _, field := range strings.Split(fields, " ") {
+		if k, v, ok := strings.Cut(field, "="); ok && slices.Contains(headerKeys, k) {
+			key = k
+			header[k] = v
+		} else if key != "" {
+			header[key] += " " + field
+		}
+	}
+}
+
+// LastAttempt returns the entries of the last attempt recorded, as a
+// transcript of a run with retries holds all of them
+func (t *Transcript) LastAttempt() []TranscriptEntry {
+	start := 0
+	for i, e := range t.Entries {
+		if e.Marker == TranscriptNote && strings.HasPrefix(e.Data, "attempt ") {
+			start = i + 1
+		}
+	}
+	return t.Entries[start:]
+}
+
+// Replay is a transport that plays back the device side of a recorded
+// CLI session, for testing models without a device. What the session
+// sends must match the transcript, so that a model whose commands or
+// expect responses changed fails instead of reading the wrong output.
+type Replay struct {
+	transport string
+	model     *config.Model
+	conn      *replayConn
+	shell     *Session
+}
+
+// replayTimeout bounds waits for output in a replay, which never block as
+// the whole transcript is at hand
+const replayTimeout = time.Minute
+
+// NewReplay creates a transport replaying the last attempt in transcript
+// for model
+func NewReplay(transcript *Transcript, model *config.Model) *Replay {
+	transport := transcript.Header["transport"]
+	if transport == "" {
+		transport = config.TransportSSH
+	}
+	return &Replay{
+		transport: transport,
+		model:     model,
+		conn:      &replayConn{entries: slices.Clone(transcript.LastAttempt())},
+	}
+}
+
+// Connect replays the initial prompt, or the Telnet login, and the
+// post_login commands. Passwords were redacted when recording, so the
+// session sends the redacted placeholder in their place.
+func (r *Replay) Connect(ctx context.Context) error {
+	switch {
+	case r.transport != config.TransportSSH && r.transport != config.TransportTelnet:
+		return fmt.Errorf("transport %q has no CLI session to replay", r.transport)
+	case r.model.Mode == config.ModeExec:
+		return fmt.Errorf("exec mode has no CLI session to replay")
+	case r.model.Prompt == "":
+		return fmt.Errorf("model has no prompt")
+	}
+
+	session := NewSession(r.conn, r.conn, r.model, replayTimeout)
+	if r.transport == config.TransportTelnet {
+		if err := session.Login(ctx, r.conn.username(), redacted); err != nil {
+			return fmt.Errorf("login: %w", err)
+		}
+	} else if _, err := session.ReadUntilPrompt(ctx); err != nil {
+		return fmt.Errorf("wait for initial prompt: %w", withPhase(err, PhaseConnect, ""))
+	}
+
+	if err := session.ExecutePostLogin(ctx, redacted); err != nil {
+		return fmt.Errorf("post-login: %w", err)
+	}
+
+	r.shell = session
+	return nil
+}
+
+// Run replays a command
+func (r *Replay) Run(ctx context.Context, cmd *config.Command) (string, error) {
+	if r.shell == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	return r.shell.Run(ctx, cmd)
+}
+
+// Close does nothing; the transcript ends where the session was closed
+func (r *Replay) Close() error {
+	return nil
+}
+
+// replayConn plays back the received chunks of a transcript in order,
+// checking that what is written matches the chunks sent in between
+type replayConn struct {
+	entries []TranscriptEntry
+	next    int
+}
+
+// skipNotes moves past notes to the next chunk
+func (c *replayConn) skipNotes() {
+	for c.next < len(c.entries) && c.entries[c.next].Marker == TranscriptNote {
+		c.next++
+	}
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	c.skipNotes()
+	if c.next == len(c.entries) {
+		return 0, fmt.Errorf("end of transcript while waiting for more output")
+	}
+	e := &c.entries[c.next]
+	if e.Marker == TranscriptSent {
+		return 0, fmt.Errorf("waiting for more output, but the transcript sends %q next: the pattern did not match where it did when recording", e.Data)
+	}
+
+	n := copy(p, e.Data)
+	if n < len(e.Data) {
+		// Keep the rest of the chunk for the next read
+		e.Data = e.Data[n:]
+	} else {
+		c.next++
+	}
+	return n, nil
+}
+
+func (c *replayConn) Write(p []byte) (int, error) {
+	c.skipNotes()
+	if c.next == len(c.entries) {
+		return 0, fmt.Errorf("sent %q after the end of the transcript", p)
+	}
+	e := c.entries[c.next]
+	if e.Marker != TranscriptSent {
+		return 0, fmt.Errorf("sent %q, but the transcript receives %q first", p, e.Data)
+	}
+	if e.Data != string(p) {
+		return 0, fmt.Errorf("sent %q, but the transcript sends %q", p, e.Data)
+	}
+	c.next++
+	return len(p), nil
+}
+
+func (c *replayConn) Close() error {
+	return nil
+}
+
+// username returns the first line sent in the transcript, which is the
+// username on a Telnet login
+func (c *replayConn) username() string {
+	for _, e := range c.entries {
+		if e.Marker == TranscriptSent {
+			return strings.TrimSuffix(e.Data, "\n")
+		}
+	}
+	return ""
+}
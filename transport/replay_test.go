@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+)
+
+// transcriptLine returns a transcript line as a Recorder writes it
+func transcriptLine(marker, data string) string {
+	if marker != TranscriptNote {
+		data = strconv.Quote(data)
+	}
+	return fmt.Sprintf("2026-10-16T08:45:00.000000Z %s %s\n", marker, data)
+}
+
+func TestReadTranscriptHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		notes []string
+		want  map[string]string
+	}{
+		{
+			name:  "header",
+			notes: []string{"netback 0.1.0 transcript: device=core-01 group=dc-tokyo model=ios transport=ssh"},
+			want:  map[string]string{"device": "core-01", "group": "dc-tokyo", "model": "ios", "transport": "ssh"},
+		},
+		{
+			name:  "names with spaces",
+			notes: []string{"netback 0.1.0 transcript: device=core 01  (rack 3) group=dc tokyo model=ios transport=telnet"},
+			want:  map[string]string{"device": "core 01  (rack 3)", "group": "dc tokyo", "model": "ios", "transport": "telnet"},
+		},
+		{
+			name:  "equals sign in a value",
+			notes: []string{"netback 0.1.0 transcript: device=a=b group=x y=z model=ios transport=ssh"},
+			want:  map[string]string{"device": "a=b", "group": "x y=z", "model": "ios", "transport": "ssh"},
+		},
+		{
+			name:  "empty group",
+			notes: []string{"netback 0.1.0 transcript: device=core-01 group= model=ios transport=ssh"},
+			want:  map[string]string{"device": "core-01", "group": "", "model": "ios", "transport": "ssh"},
+		},
+		{
+			name:  "not a header",
+			notes: []string{"attempt 1"},
+			want:  map[string]string{},
+		},
+		{
+			name:  "header only from the first note",
+			notes: []string{"attempt 1", "netback 0.1.0 transcript: device=core-01 group=dc-tokyo model=ios transport=ssh"},
+			want:  map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			for _, note := range tt.notes {
+				b.WriteString(transcriptLine(TranscriptNote, note))
+			}
+			transcript, err := ReadTranscript(strings.NewReader(b.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(transcript.Header, tt.want) {
+				t.Errorf("header = %q, want %q", transcript.Header, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTranscriptErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		err  string
+	}{
+		{name: "missing data", line: "2026-10-16T08:45:00.000000Z #", err: "line 2: expected timestamp, marker and data"},
+		{name: "timestamp", line: "yesterday < \"router#\"", err: "line 2: parsing time"},
+		{name: "unquoted data", line: "2026-10-16T08:45:00.000000Z < router#", err: "line 2: invalid data"},
+		{name: "unknown marker", line: "2026-10-16T08:45:00.000000Z ? \"router#\"", err: `line 2: unknown marker "?"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := transcriptLine(TranscriptNote, "attempt 1") + tt.line + "\n"
+			_, err := ReadTranscript(strings.NewReader(data))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTranscriptLastAttempt(t *testing.T) {
+	note := func(s string) TranscriptEntry { return TranscriptEntry{Marker: TranscriptNote, Data: s} }
+	received := func(s string) TranscriptEntry { return TranscriptEntry{Marker: TranscriptReceived, Data: s} }
+
+	tests := []struct {
+		name    string
+		entries []TranscriptEntry
+		want    []string
+	}{
+		{
+			name:    "no attempts noted",
+			entries: []TranscriptEntry{note("transcript: device=r1"), received("router#")},
+			want:    []string{"transcript: device=r1", "router#"},
+		},
+		{
+			name:    "one attempt",
+			entries: []TranscriptEntry{note("transcript: device=r1"), note("attempt 1"), received("router#")},
+			want:    []string{"router#"},
+		},
+		{
+			name: "retried",
+			entries: []TranscriptEntry{
+				note("transcript: device=r1"),
+				note("attempt 1"), received("Connection reset"), note("read error: EOF"),
+				note("attempt 2"), received("router#"),
+			},
+			want: []string{"router#"},
+		},
+		{
+			name:    "last attempt recorded nothing",
+			entries: []TranscriptEntry{note("attempt 1"), received("router#"), note("attempt 2")},
+			want:    nil,
+		},
+		{
+			name:    "note that only mentions an attempt",
+			entries: []TranscriptEntry{note("attempt 1"), note("gave up waiting: attempt 2 timed out"), received("router#")},
+			want:    []string{"gave up waiting: attempt 2 timed out", "router#"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range (&Transcript{Entries: tt.entries}).LastAttempt() {
+				got = append(got, e.Data)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("LastAttempt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayConnMismatch(t *testing.T) {
+	sent := func(s string) TranscriptEntry { return TranscriptEntry{Marker: TranscriptSent, Data: s} }
+	received := func(s string) TranscriptEntry { return TranscriptEntry{Marker: TranscriptReceived, Data: s} }
+	note := TranscriptEntry{Marker: TranscriptNote, Data: "matched"}
+
+	tests := []struct {
+		name    string
+		entries []TranscriptEntry
+		// write is sent to the connection; empty reads from it instead
+		write string
+		err   string
+	}{
+		{
+			name:    "sent differs",
+			entries: []TranscriptEntry{note, sent("show version\n")},
+			write:   "show running-config\n",
+			err:     `sent "show running-config\n", but the transcript sends "show version\n"`,
+		},
+		{
+			name:    "sent when the transcript receives first",
+			entries: []TranscriptEntry{received("--More--"), sent(" ")},
+			write:   " ",
+			err:     `sent " ", but the transcript receives "--More--" first`,
+		},
+		{
+			name:    "sent after the end",
+			entries: []TranscriptEntry{note},
+			write:   "exit\n",
+			err:     `sent "exit\n" after the end of the transcript`,
+		},
+		{
+			name:    "read when the transcript sends next",
+			entries: []TranscriptEntry{note, sent("show version\n")},
+			err:     `waiting for more output, but the transcript sends "show version\n" next: the pattern did not match where it did when recording`,
+		},
+		{
+			name:    "end of transcript",
+			entries: []TranscriptEntry{note},
+			err:     "end of transcript while waiting for more output",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &replayConn{entries: tt.entries}
+			var err error
+			if tt.write != "" {
+				_, err = c.Write([]byte(tt.write))
+			} else {
+				_, err = c.Read(make([]byte, 64))
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReplayConnPartialRead(t *testing.T) {
+	c := &replayConn{entries: []TranscriptEntry{
+		{Marker: TranscriptReceived, Data: "router#"},
+		{Marker: TranscriptNote, Data: "matched"},
+		{Marker: TranscriptSent, Data: "show clock\n"},
+	}}
+
+	// A chunk larger than the buffer is read over several calls
+	var got []string
+	buf := make([]byte, 3)
+	for range 3 {
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	if strings.Join(got, "|") != "rou|ter|#" {
+		t.Errorf("reads = %q", got)
+	}
+	if _, err := c.Write([]byte("show clock\n")); err != nil {
+		t.Errorf("write after the chunk: %v", err)
+	}
+}
+
+func TestReplayCommandChanged(t *testing.T) {
+	data := transcriptLine(TranscriptNote, "netback 0.1.0 transcript: device=r 1 group=lab model=ios transport=ssh") +
+		transcriptLine(TranscriptNote, "attempt 1") +
+		transcriptLine(TranscriptReceived, "router#") +
+		transcriptLine(TranscriptSent, "show version\n") +
+		transcriptLine(TranscriptReceived, "show version\r\nVersion 17.9\r\nrouter#")
+	transcript, err := ReadTranscript(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := NewReplay(transcript, &config.Model{Prompt: `router#$`})
+	ctx := context.Background()
+	if err := replay.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = replay.Run(ctx, &config.Command{Cmd: "show running-config"})
+	if err == nil || !strings.Contains(err.Error(), `sent "show running-config\n", but the transcript sends "show version\n"`) {
+		t.Errorf("err = %v, want a mismatch on the command sent", err)
+	}
+}
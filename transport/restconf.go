@@ -0,0 +1,234 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zinrai/netback/config"
+)
+
+// defaultRestconfRoot is used when the device does not publish host-meta
+const defaultRestconfRoot = "/restconf"
+
+func init() {
+	Register(config.TransportRESTCONF, func(device *config.Device, model *config.Model, opts *Options) Transport {
+		return NewRestconfClient(device, model, opts)
+	})
+}
+
+// RestconfClient retrieves configuration over RESTCONF (RFC 8040)
+type RestconfClient struct {
+	device  *config.Device
+	model   *config.Model
+	opts    *Options
+	client  *http.Client
+	baseURL string
+	// root is the URL of the API root, without a trailing slash
+	root string
+}
+
+// NewRestconfClient creates a new RESTCONF client for the device
+func NewRestconfClient(device *config.Device, model *config.Model, opts *Options) *RestconfClient {
+	return &RestconfClient{
+		device: device,
+		model:  model,
+		opts:   opts,
+	}
+}
+
+// Connect discovers the RESTCONF API root
+func (c *RestconfClient) Connect(ctx context.Context) error {
+	c.opts.logger().Info("connecting", "phase", PhaseConnect)
+
+	client, baseURL, err := newHTTPClient(c.device, c.opts)
+	if err != nil {
+		return err
+	}
+	c.client = client
+	c.baseURL = baseURL
+
+	ctx, cancel := context.WithTimeout(ctx, c.device.EffectiveTimeout())
+	defer cancel()
+	root, err := c.discoverRoot(ctx)
+	if err != nil {
+		return fmt.Errorf("discover api root: %w", err)
+	}
+	c.root = root
+	c.opts.logger().Debug("discovered api root", "phase", PhaseConnect, "root", root)
+
+	return nil
+}
+
+// discoverRoot reads the API root from /.well-known/host-meta (RFC 8040
+// section 3.1), resolving its link against the host-meta URL
+func (c *RestconfClient) discoverRoot(ctx context.Context) (string, error) {
+	hostMeta := c.baseURL + "/.well-known/host-meta"
+	data, status, err := c.get(ctx, hostMeta, "application/xrd+xml")
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		return c.baseURL + defaultRestconfRoot, nil
+	}
+	if err := checkRestconfStatus(status, data); err != nil {
+		return "", err
+	}
+
+	var xrd struct {
+		Links []struct {
+			Rel  string `xml:"rel,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"Link"`
+	}
+	if err := xml.Unmarshal(data, &xrd); err != nil {
+		return "", fmt.Errorf("parse host-meta: %w", err)
+	}
+	for _, link := range xrd.Links {
+		if link.Rel != "restconf" || link.Href == "" {
+			continue
+		}
+		base, err := url.Parse(hostMeta)
+		if err != nil {
+			return "", err
+		}
+		href, err := url.Parse(link.Href)
+		if err != nil {
+			return "", fmt.Errorf("parse host-meta link %q: %w", link.Href, err)
+		}
+		return strings.TrimSuffix(base.ResolveReference(href).String(), "/"), nil
+	}
+	return c.baseURL + defaultRestconfRoot, nil
+}
+
+// Run fetches a YANG path below the data resource, or the whole datastore for "/"
+func (c *RestconfClient) Run(ctx context.Context, cmd *config.Command) (string, error) {
+	if c.client == nil {
+		return "", fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cmd.EffectiveTimeout(c.device.EffectiveTimeout()))
+	defer cancel()
+
+	dataURL := c.root + "/data"
+	if path := strings.Trim(cmd.Cmd, "/"); path != "" {
+		dataURL += "/" + path
+	}
+	dataURL += "?content=config"
+
+	encoding := c.model.Restconf.EffectiveEncoding()
+	data, status, err := c.get(ctx, dataURL, "application/yang-data+"+encoding)
+	if err != nil {
+		return "", err
+	}
+	if err := checkRestconfStatus(status, data); err != nil {
+		return "", err
+	}
+
+	if encoding == config.EncodingXML {
+		return formatXML(data, c.model.Restconf.Format)
+	}
+	return formatJSON(data, c.model.Restconf.Format)
+}
+
+// get performs an authenticated GET, returning the body and status code
+func (c *RestconfClient) get(ctx context.Context, url, accept string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	req.SetBasicAuth(c.device.Username, c.device.Password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read response: %w", err)
+	}
+	return data, resp.StatusCode, nil
+}
+
+// checkRestconfStatus turns an error status into an error, using the
+// error-message of a RESTCONF errors body when present
+func checkRestconfStatus(status int, body []byte) error {
+	if status >= 200 && status < 300 {
+		return nil
+	}
+	if status == http.StatusUnauthorized {
+		return &AuthError{Phase: PhaseConnect, Err: fmt.Errorf("authentication failed: %d %s", status, http.StatusText(status))}
+	}
+
+	var errs struct {
+		Errors struct {
+			Error []struct {
+				Message string `json:"error-message"`
+			} `json:"error"`
+		} `json:"ietf-restconf:errors"`
+	}
+	if json.Unmarshal(body, &errs) == nil && len(errs.Errors.Error) > 0 {
+		var messages []string
+		for _, e := range errs.Errors.Error {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("unexpected status %d: %s", status, strings.Join(messages, "; "))
+	}
+	return fmt.Errorf("unexpected status %d %s", status, http.StatusText(status))
+}
+
+// Framed reports false: responses carry no command echo or prompt
+func (c *RestconfClient) Framed() bool {
+	return false
+}
+
+// Close releases idle HTTP connections
+func (c *RestconfClient) Close() error {
+	if c.client != nil {
+		c.client.CloseIdleConnections()
+		c.client = nil
+	}
+	return nil
+}
+
+// formatJSON rewrites a JSON document in the given format (see config.Format*).
+// canonical sorts object members by name.
+func formatJSON(data []byte, format string) (string, error) {
+	switch format {
+	case "", config.FormatRaw:
+		return string(data), nil
+	case config.FormatPretty:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return "", fmt.Errorf("parse json: %w", err)
+		}
+		buf.WriteByte('\n')
+		return buf.String(), nil
+	case config.FormatCanonical:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return "", fmt.Errorf("parse json: %w", err)
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return "", fmt.Errorf("encode json: %w", err)
+		}
+		return buf.String(), nil
+	default:
+		return "", fmt.Errorf("unknown json format %q", format)
+	}
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/netback/config"
+)
+
+func TestRestconfDiscoverRoot(t *testing.T) {
+	tests := []struct {
+		name string
+		// hostMeta is the host-meta document; empty answers 404
+		hostMeta func(srvURL string) string
+		// want is the path the data resource is requested under
+		want string
+	}{
+		{
+			name: "no host-meta",
+			want: "/restconf/data/Cisco-IOS-XE-native:native",
+		},
+		{
+			name: "absolute path",
+			hostMeta: func(string) string {
+				return `<XRD xmlns="http://docs.oasis-open.org/ns/xri/xrd-1.0"><Link rel="restconf" href="/api/restconf/"/></XRD>`
+			},
+			want: "/api/restconf/data/Cisco-IOS-XE-native:native",
+		},
+		{
+			name: "relative path",
+			hostMeta: func(string) string {
+				return `<XRD><Link rel="restconf" href="../top/restconf"/></XRD>`
+			},
+			want: "/top/restconf/data/Cisco-IOS-XE-native:native",
+		},
+		{
+			name: "absolute URL",
+			hostMeta: func(srvURL string) string {
+				return fmt.Sprintf(`<XRD><Link rel="restconf" href="%s/rc"/></XRD>`, srvURL)
+			},
+			want: "/rc/data/Cisco-IOS-XE-native:native",
+		},
+		{
+			name: "no restconf link",
+			hostMeta: func(string) string {
+				return `<XRD><Link rel="author" href="/people"/></XRD>`
+			},
+			want: "/restconf/data/Cisco-IOS-XE-native:native",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/.well-known/host-meta" {
+					if tt.hostMeta == nil {
+						http.NotFound(w, r)
+						return
+					}
+					w.Write([]byte(tt.hostMeta(srv.URL)))
+					return
+				}
+				got = r.URL.Path
+				if q := r.URL.Query().Get("content"); q != "config" {
+					t.Errorf("content = %q, want config", q)
+				}
+				w.Write([]byte(`{}`))
+			}))
+			t.Cleanup(srv.Close)
+
+			c := newRestconfTest(t, srv, &config.Model{})
+			if _, err := c.Run(context.Background(), &config.Command{Cmd: "Cisco-IOS-XE-native:native"}); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("requested %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// newRestconfTest returns a client connected to srv
+func newRestconfTest(t *testing.T, srv *httptest.Server, model *config.Model) *RestconfClient {
+	t.Helper()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := &config.Device{Name: "r1", IP: host}
+	device.Port, _ = strconv.Atoi(port)
+	device.TLS.Disable = true
+
+	c := NewRestconfClient(device, model, &Options{Dialer: NewDialer(nil, nil), Logger: discardLogger})
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
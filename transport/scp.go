@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fetchSCP reads a whole file by running "scp -f" (source mode) on the server
+func fetchSCP(client *ssh.Client, path string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("new session: %w", err)
+	}
+	defer session.Close()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	r := bufio.NewReader(stdout)
+
+	if err := session.Start("scp -f " + shellQuote(path)); err != nil {
+		return nil, fmt.Errorf("scp %s: %w", path, err)
+	}
+
+	data, err := receiveSCP(w, r)
+	if err != nil {
+		return nil, fmt.Errorf("scp %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// receiveSCP runs the sink side of the SCP protocol for a single file
+func receiveSCP(w io.Writer, r *bufio.Reader) ([]byte, error) {
+	ack := func() error {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+
+	if err := ack(); err != nil {
+		return nil, err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return nil, fmt.Errorf("empty header")
+		}
+
+		switch line[0] {
+		case 1, 2:
+			// Warning or fatal error from the server
+			return nil, fmt.Errorf("%s", line[1:])
+		case 'T':
+			// Timestamps (sent with -p); acknowledge and wait for the file
+			if err := ack(); err != nil {
+				return nil, err
+			}
+			continue
+		case 'C':
+		default:
+			return nil, fmt.Errorf("unexpected header %q", line)
+		}
+
+		// C<mode> <size> <name>
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed header %q", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("malformed size in header %q", line)
+		}
+		if size > maxFileSize {
+			return nil, fmt.Errorf("file too large: %d bytes, limit %d", size, maxFileSize)
+		}
+
+		if err := ack(); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("read content: %w", err)
+		}
+
+		status, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read status: %w", err)
+		}
+		if status != 0 {
+			msg, _ := r.ReadString('\n')
+			return nil, fmt.Errorf("%s", strings.TrimSpace(msg))
+		}
+		if err := ack(); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
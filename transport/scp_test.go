@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReceiveSCP(t *testing.T) {
+	tests := []struct {
+		name string
+		// input is what the server sends
+		input string
+		want  string
+		// acks is the number of acknowledgements sent before returning
+		acks int
+		err  string
+	}{
+		{name: "file", input: "C0644 6 config\nhello\n\x00", want: "hello\n", acks: 3},
+		{name: "empty file", input: "C0644 0 config\n\x00", want: "", acks: 3},
+		{name: "name with spaces", input: "C0600 2 my config\nok\x00", want: "ok", acks: 3},
+		{name: "timestamps first", input: "T1700000000 0 1700000000 0\nC0644 2 config\nok\x00", want: "ok", acks: 4},
+		{name: "warning", input: "\x01scp: /config: No such file or directory\n", err: "scp: /config: No such file or directory", acks: 1},
+		{name: "fatal error", input: "\x02scp: ambiguous target\n", err: "scp: ambiguous target", acks: 1},
+		{name: "truncated header", input: "C0644 6 con", err: "read header", acks: 1},
+		{name: "no header", input: "", err: "read header", acks: 1},
+		{name: "empty header", input: "\n", err: "empty header", acks: 1},
+		{name: "directory", input: "D0755 0 etc\n", err: "unexpected header", acks: 1},
+		{name: "missing name", input: "C0644 6\n", err: "malformed header", acks: 1},
+		{name: "non-numeric size", input: "C0644 six config\n", err: "malformed size", acks: 1},
+		{name: "negative size", input: "C0644 -1 config\n", err: "malformed size", acks: 1},
+		{name: "size overflow", input: "C0644 99999999999999999999 config\n", err: "malformed size", acks: 1},
+		{
+			name:  "oversize",
+			input: "C0644 " + strconv.Itoa(maxFileSize+1) + " config\n",
+			err:   "file too large",
+			acks:  1,
+		},
+		{name: "truncated content", input: "C0644 6 config\nhel", err: "read content", acks: 2},
+		{name: "missing status", input: "C0644 2 config\nok", err: "read status", acks: 2},
+		{name: "error status", input: "C0644 2 config\nok\x01scp: read error\n", err: "scp: read error", acks: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent bytes.Buffer
+			data, err := receiveSCP(&sent, bufio.NewReader(strings.NewReader(tt.input)))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(data) != tt.want {
+					t.Errorf("data = %q, want %q", data, tt.want)
+				}
+			}
+			if want := strings.Repeat("\x00", tt.acks); sent.String() != want {
+				t.Errorf("sent %q, want %q", sent.String(), want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"/config/config.boot": `'/config/config.boot'`,
+		"it's":                `'it'\''s'`,
+		"$(reboot)":           `'$(reboot)'`,
+	} {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package transport
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/zinrai/netback/config"
@@ -17,127 +20,347 @@ type Session struct {
 	model   *config.Model
 	timeout time.Duration
 	buffer  bytes.Buffer
+	// recorder records everything sent and read, if set
+	recorder *Recorder
+
+	// A read from stdout runs in its own goroutine, so that giving up on
+	// it leaves it pending for the next wait instead of closing the
+	// connection
+	readBuf []byte
+	reads   chan readResult
+	pending bool
+}
+
+// readResult is the outcome of a read from stdout
+type readResult struct {
+	data []byte
+	err  error
 }
 
-// NewSession creates a new session wrapper
+// NewSession creates a new session wrapper. Each wait for output is bounded
+// by timeout. A wait that times out leaves the session usable: output
+// arriving later is read by the next wait. The connection behind stdout
+// must be closed to end a pending read.
 func NewSession(stdin io.Writer, stdout io.Reader, model *config.Model, timeout time.Duration) *Session {
 	return &Session{
 		stdin:   stdin,
 		stdout:  stdout,
 		model:   model,
 		timeout: timeout,
+		readBuf: make([]byte, 4096),
+		reads:   make(chan readResult, 1),
 	}
 }
 
 // ReadUntilPrompt reads output until the prompt is detected
-func (s *Session) ReadUntilPrompt() (string, error) {
+func (s *Session) ReadUntilPrompt(ctx context.Context) (string, error) {
 	promptRe, err := s.model.PromptRegex()
 	if err != nil {
 		return "", err
 	}
-	return s.readUntil(promptRe)
+	return s.readUntil(ctx, promptRe, s.model.Expect, s.timeout)
 }
 
 // ReadUntilPattern reads output until the given pattern is detected
-func (s *Session) ReadUntilPattern(pattern *regexp.Regexp) (string, error) {
-	return s.readUntil(pattern)
+func (s *Session) ReadUntilPattern(ctx context.Context, pattern *regexp.Regexp) (string, error) {
+	return s.readUntil(ctx, pattern, s.model.Expect, s.timeout)
 }
 
-func (s *Session) readUntil(pattern *regexp.Regexp) (string, error) {
+// Pattern matching only looks at the end of the buffer, so that reading
+// large outputs stays linear: each read is matched together with the
+// matchOverlap bytes before it, extended back to the start of that line
+// (at most maxLineLength further), so that prompts and pager markers split
+// across reads are still found and line anchors work as on the whole buffer.
+const (
+	matchOverlap  = 1024
+	maxLineLength = 16 * 1024
+)
+
+// readUntil reads output until pattern is detected, answering the expect
+// rules on the way, for at most timeout
+func (s *Session) readUntil(ctx context.Context, pattern *regexp.Regexp, expect []config.ExpectRule, timeout time.Duration) (string, error) {
 	s.buffer.Reset()
-	buf := make([]byte, 4096)
-	deadline := time.Now().Add(s.timeout)
 
-	for {
-		if time.Now().After(deadline) {
-			return s.buffer.String(), fmt.Errorf("timeout waiting for pattern")
-		}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-		n, err := s.stdout.Read(buf)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return s.buffer.String(), fmt.Errorf("read error: %w", err)
-		}
+	s.recorder.Note("waiting for `%s` (timeout %s)", pattern, timeout)
+	for {
+		data, err := s.read(ctx)
 
-		if n > 0 {
-			s.buffer.Write(buf[:n])
+		if len(data) > 0 {
+			s.recorder.Received(data)
+			s.buffer.Write(data)
+			start := windowStart(s.buffer.Bytes(), s.buffer.Len()-len(data))
 
 			// Process expect rules (pager handling, etc.)
-			content := s.buffer.String()
-			content, handled := s.processExpectRules(content)
-			if handled {
-				s.buffer.Reset()
-				s.buffer.WriteString(content)
-			}
+			s.processExpectRules(expect, start)
 
 			// Check for prompt
-			if pattern.MatchString(s.buffer.String()) {
-				break
+			if pattern.Match(s.buffer.Bytes()[start:]) {
+				s.recorder.Note("matched")
+				return s.buffer.String(), nil
 			}
 		}
+
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			ctxErr := contextError(ctx, "waiting for pattern")
+			s.recorder.Note("gave up waiting: %v", ctxErr)
+			return s.buffer.String(), promptTimeout(ctxErr, timeout)
+		case err == io.EOF:
+			s.recorder.Note("connection closed")
+			return s.buffer.String(), nil
+		default:
+			s.recorder.Note("read error: %v", err)
+			return s.buffer.String(), fmt.Errorf("read error: %w", err)
+		}
 	}
+}
 
-	return s.buffer.String(), nil
+// read returns the next chunk of output, waiting until ctx is done. A read
+// still pending then is picked up by the next call.
+func (s *Session) read(ctx context.Context) ([]byte, error) {
+	if !s.pending {
+		s.pending = true
+		go func() {
+			n, err := s.stdout.Read(s.readBuf)
+			s.reads <- readResult{data: s.readBuf[:n], err: err}
+		}()
+	}
+
+	select {
+	case r := <-s.reads:
+		s.pending = false
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
-// processExpectRules handles expect patterns (like pager responses)
-func (s *Session) processExpectRules(content string) (string, bool) {
-	handled := false
+// windowStart returns the offset from which to match when data was
+// appended to buf at offset from
+func windowStart(buf []byte, from int) int {
+	start := max(from-matchOverlap, 0)
+	lo := max(start-maxLineLength, 0)
+	if i := bytes.LastIndexByte(buf[lo:start], '\n'); i >= 0 {
+		return lo + i + 1
+	}
+	return lo
+}
 
-	for _, rule := range s.model.Expect {
+// processExpectRules handles expect patterns (like pager responses) in the
+// buffer from offset start on
+func (s *Session) processExpectRules(rules []config.ExpectRule, start int) {
+	for i := range rules {
+		rule := &rules[i]
 		re, err := rule.Regex()
 		if err != nil {
 			continue
 		}
 
-		if re.MatchString(content) {
-			if rule.Send != "" {
-				// Send response (e.g., space for pager)
-				s.Send(rule.Send)
-			}
-			if rule.Replace != "" || rule.Send != "" {
-				// Replace the pattern in output
-				content = re.ReplaceAllString(content, rule.Replace)
-				handled = true
-			}
+		window := s.buffer.Bytes()[start:]
+		if !re.Match(window) {
+			continue
+		}
+		if rule.Send != "" {
+			// Send response (e.g., space for pager)
+			s.Send(rule.Send)
+		}
+		if rule.Replace != "" || rule.Send != "" {
+			// Replace the pattern in output
+			replaced := re.ReplaceAll(window, []byte(rule.Replace))
+			s.buffer.Truncate(start)
+			s.buffer.Write(replaced)
 		}
 	}
-
-	return content, handled
 }
 
 // Send sends a command without waiting for response
 func (s *Session) Send(cmd string) error {
+	s.recorder.Sent([]byte(cmd))
 	_, err := fmt.Fprint(s.stdin, cmd)
 	return err
 }
 
 // SendLine sends a command followed by newline
 func (s *Session) SendLine(cmd string) error {
-	_, err := fmt.Fprintln(s.stdin, cmd)
+	return s.Send(cmd + "\n")
+}
+
+// sendSecret sends a password followed by newline, recording it redacted
+func (s *Session) sendSecret(secret string) error {
+	s.recorder.Sent([]byte(redacted + "\n"))
+	_, err := fmt.Fprintln(s.stdin, secret)
 	return err
 }
 
 // Execute sends a command and waits for the prompt
-func (s *Session) Execute(cmd string) (string, error) {
+func (s *Session) Execute(ctx context.Context, cmd string) (string, error) {
 	if err := s.SendLine(cmd); err != nil {
 		return "", fmt.Errorf("send command: %w", err)
 	}
-	return s.ReadUntilPrompt()
+	return s.ReadUntilPrompt(ctx)
+}
+
+// Run sends a model command and waits for its prompt (or the model prompt),
+// applying its expect rules before the model's, within its timeout.
+//
+// When an optional command times out, Run waits up to the session timeout
+// for the prompt to come back, so that the commands after it can run. If it
+// does not, or sending or reading fails, the error is a SessionLost.
+func (s *Session) Run(ctx context.Context, cmd *config.Command) (string, error) {
+	promptRe, err := cmd.PromptRegex()
+	if err != nil {
+		return "", err
+	}
+	if promptRe == nil {
+		if promptRe, err = s.model.PromptRegex(); err != nil {
+			return "", err
+		}
+	}
+
+	expect := s.model.Expect
+	if len(cmd.Expect) > 0 {
+		expect = append(cmd.Expect[:len(cmd.Expect):len(cmd.Expect)], s.model.Expect...)
+	}
+
+	if err := s.SendLine(cmd.Cmd); err != nil {
+		return "", &SessionLost{Err: fmt.Errorf("send command: %w", err)}
+	}
+	output, err := s.readUntil(ctx, promptRe, expect, cmd.EffectiveTimeout(s.timeout))
+	err = withPhase(err, PhaseCommand, cmd.Cmd)
+
+	var pt *PromptTimeout
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+	case errors.As(err, &pt):
+		if cmd.Optional {
+			// Let the command finish, discarding the rest of its output
+			s.recorder.Note("waiting for the prompt to return")
+			if _, resyncErr := s.readUntil(ctx, promptRe, expect, s.timeout); resyncErr != nil {
+				err = fmt.Errorf("prompt did not return after the command timed out: %w", withPhase(resyncErr, PhaseCommand, cmd.Cmd))
+				return output, &SessionLost{Err: err}
+			}
+		}
+	default:
+		err = &SessionLost{Err: err}
+	}
+	return output, err
 }
 
-// ExecutePostLogin runs the post-login commands
-func (s *Session) ExecutePostLogin() error {
+// Login answers the username and password prompts and waits for the device prompt
+func (s *Session) Login(ctx context.Context, username, password string) error {
+	userRe, err := s.model.Login.UsernameRegex()
+	if err != nil {
+		return err
+	}
+	passRe, err := s.model.Login.PasswordRegex()
+	if err != nil {
+		return err
+	}
+	promptRe, err := s.model.PromptRegex()
+	if err != nil {
+		return err
+	}
+	pattern := anyOf(userRe, passRe, promptRe)
+
+	sentUsername, sentPassword := false, false
+	for {
+		output, err := s.readUntil(ctx, pattern, s.model.Expect, s.timeout)
+		if err != nil {
+			return fmt.Errorf("wait for login prompt: %w", withPhase(err, PhaseLogin, ""))
+		}
+
+		// Login prompts are checked first since device prompt patterns are usually looser
+		switch {
+		case passRe.MatchString(output):
+			if sentPassword {
+				return &AuthError{Phase: PhaseLogin, Err: fmt.Errorf("login failed: password rejected")}
+			}
+			if err := s.sendSecret(password); err != nil {
+				return fmt.Errorf("send password: %w", err)
+			}
+			sentPassword = true
+		case userRe.MatchString(output):
+			if sentPassword {
+				return &AuthError{Phase: PhaseLogin, Err: fmt.Errorf("login failed: credentials rejected")}
+			}
+			if sentUsername {
+				return fmt.Errorf("login failed: username prompt repeated")
+			}
+			if err := s.SendLine(username); err != nil {
+				return fmt.Errorf("send username: %w", err)
+			}
+			sentUsername = true
+		case promptRe.MatchString(output):
+			return nil
+		default:
+			return fmt.Errorf("connection closed during login")
+		}
+	}
+}
+
+// ExecutePostLogin runs the post-login commands, answering the enable
+// prompt with enablePassword when the model defines one
+func (s *Session) ExecutePostLogin(ctx context.Context, enablePassword string) error {
+	enableRe, err := s.model.Login.EnableRegex()
+	if err != nil {
+		return err
+	}
+
 	for _, cmd := range s.model.Connection.PostLogin {
-		if _, err := s.Execute(cmd); err != nil {
-			return fmt.Errorf("execute %q: %w", cmd, err)
+		if enableRe == nil {
+			if _, err := s.Execute(ctx, cmd); err != nil {
+				return fmt.Errorf("execute %q: %w", cmd, withPhase(err, PhasePostLogin, cmd))
+			}
+			continue
+		}
+		if err := s.executeWithEnable(ctx, cmd, enableRe, enablePassword); err != nil {
+			return fmt.Errorf("execute %q: %w", cmd, withPhase(err, PhasePostLogin, cmd))
 		}
 	}
 	return nil
 }
 
+// executeWithEnable sends a command and answers the enable prompt if it appears
+func (s *Session) executeWithEnable(ctx context.Context, cmd string, enableRe *regexp.Regexp, enablePassword string) error {
+	promptRe, err := s.model.PromptRegex()
+	if err != nil {
+		return err
+	}
+
+	if err := s.SendLine(cmd); err != nil {
+		return fmt.Errorf("send command: %w", err)
+	}
+	output, err := s.readUntil(ctx, anyOf(enableRe, promptRe), s.model.Expect, s.timeout)
+	if err != nil {
+		return err
+	}
+	if !enableRe.MatchString(output) {
+		return nil
+	}
+
+	if enablePassword == "" {
+		return fmt.Errorf("enable prompt received but enable_password is not set")
+	}
+	if err := s.sendSecret(enablePassword); err != nil {
+		return fmt.Errorf("send enable password: %w", err)
+	}
+	_, err = s.ReadUntilPrompt(ctx)
+	return err
+}
+
+// anyOf combines patterns into one that matches when any of them does
+func anyOf(patterns ...*regexp.Regexp) *regexp.Regexp {
+	parts := make([]string, len(patterns))
+	for i, p := range patterns {
+		parts[i] = "(?:" + p.String() + ")"
+	}
+	return regexp.MustCompile(strings.Join(parts, "|"))
+}
+
 // ExecutePreLogout runs the pre-logout command
 func (s *Session) ExecutePreLogout() error {
 	if s.model.Connection.PreLogout != "" {
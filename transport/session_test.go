@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+)
+
+// newSessionTest returns a session whose device answers each command line
+// with respond; an empty answer sends nothing
+func newSessionTest(t *testing.T, timeout time.Duration, respond func(cmd string) (string, time.Duration)) *Session {
+	t.Helper()
+	deviceR, sessionW := io.Pipe()
+	sessionR, deviceW := io.Pipe()
+	t.Cleanup(func() {
+		sessionW.Close()
+		sessionR.Close()
+	})
+
+	go func() {
+		defer deviceW.Close()
+		lines := bufio.NewScanner(deviceR)
+		for lines.Scan() {
+			out, delay := respond(lines.Text())
+			time.Sleep(delay)
+			if _, err := io.WriteString(deviceW, out); err != nil {
+				return
+			}
+		}
+	}()
+
+	return NewSession(sessionW, sessionR, &config.Model{Prompt: `router#$`}, timeout)
+}
+
+func TestRunOptionalTimeout(t *testing.T) {
+	s := newSessionTest(t, time.Second, func(cmd string) (string, time.Duration) {
+		if cmd == "show tech-support" {
+			return "tech-support output\r\nrouter#", 200 * time.Millisecond
+		}
+		return cmd + "\r\nversion 1.0\r\nrouter#", 0
+	})
+
+	slow := &config.Command{Cmd: "show tech-support", Timeout: 50 * time.Millisecond, Optional: true}
+	_, err := s.Run(context.Background(), slow)
+	var pt *PromptTimeout
+	if !errors.As(err, &pt) {
+		t.Fatalf("err = %v, want a PromptTimeout", err)
+	}
+	var lost *SessionLost
+	if errors.As(err, &lost) {
+		t.Fatalf("err = %v, want the session kept", err)
+	}
+
+	// The late output of the timed out command does not end up here
+	out, err := s.Run(context.Background(), &config.Command{Cmd: "show version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "show version\r\nversion 1.0\r\nrouter#" {
+		t.Errorf("output = %q", out)
+	}
+}
+
+func TestRunSessionLost(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  config.Command
+		// closed closes the connection before the command is sent
+		closed bool
+		err    string
+	}{
+		{
+			name: "prompt does not return",
+			cmd:  config.Command{Cmd: "show tech-support", Timeout: 50 * time.Millisecond, Optional: true},
+			err:  "session lost: prompt did not return after the command timed out",
+		},
+		{
+			name:   "connection closed",
+			cmd:    config.Command{Cmd: "show version", Optional: true},
+			closed: true,
+			err:    "session lost: send command",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSessionTest(t, 100*time.Millisecond, func(string) (string, time.Duration) { return "", 0 })
+			if tt.closed {
+				s.stdin.(io.Closer).Close()
+			}
+
+			_, err := s.Run(context.Background(), &tt.cmd)
+			var lost *SessionLost
+			if !errors.As(err, &lost) || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	// A command that is not optional fails on timeout without waiting for
+	// the prompt to return
+	s := newSessionTest(t, time.Minute, func(string) (string, time.Duration) { return "", 0 })
+
+	start := time.Now()
+	_, err := s.Run(context.Background(), &config.Command{Cmd: "show tech-support", Timeout: 50 * time.Millisecond})
+	var pt *PromptTimeout
+	if !errors.As(err, &pt) {
+		t.Fatalf("err = %v, want a PromptTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s", elapsed)
+	}
+}
+
+// BenchmarkReadUntilPrompt reads multi-megabyte outputs in 4 KiB reads.
+// Throughput (MB/s) should stay flat as the size grows.
+func BenchmarkReadUntilPrompt(b *testing.B) {
+	model := &config.Model{
+		Prompt: `(?m)^router#\s*$`,
+		Expect: []config.ExpectRule{
+			{Pattern: `--More--`, Send: " "},
+		},
+	}
+
+	for _, mib := range []int{1, 4, 16} {
+		output := benchmarkOutput(mib << 20)
+
+		b.Run(fmt.Sprintf("%dMiB", mib), func(b *testing.B) {
+			b.SetBytes(int64(len(output)))
+			for i := 0; i < b.N; i++ {
+				s := NewSession(io.Discard, bytes.NewReader(output), model, time.Minute)
+				if _, err := s.ReadUntilPrompt(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchmarkOutput builds a config of about size bytes ending with the prompt
+func benchmarkOutput(size int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, "interface Ethernet%d\r\n   description uplink-%d\r\n   mtu 9214\r\n!\r\n", i, i)
+	}
+	buf.WriteString("router#")
+	return buf.Bytes()
+}
@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP version 3 packet types and status codes (draft-ietf-secsh-filexfer-02)
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpRead    = 5
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpData    = 103
+
+	sftpStatusOK  = 0
+	sftpStatusEOF = 1
+
+	sftpOpenRead = 0x00000001
+
+	// sftpReadSize is the chunk requested per read; servers must support 32KiB
+	sftpReadSize = 32 * 1024
+	// sftpMaxPacket bounds responses so a broken server cannot exhaust memory
+	sftpMaxPacket = 256 * 1024
+)
+
+// errSFTPUnavailable is returned when the server has no SFTP subsystem
+var errSFTPUnavailable = errors.New("sftp subsystem unavailable")
+
+var sftpStatusNames = map[uint32]string{
+	2: "no such file",
+	3: "permission denied",
+	4: "failure",
+	5: "bad message",
+	8: "operation unsupported",
+}
+
+// sftpConn is a minimal read-only SFTP client over a session channel
+type sftpConn struct {
+	w  io.WriteCloser
+	r  io.Reader
+	id uint32
+}
+
+// fetchSFTP reads a whole file over the SFTP subsystem
+func fetchSFTP(client *ssh.Client, path string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("new session: %w", err)
+	}
+	defer session.Close()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, errSFTPUnavailable
+	}
+
+	c := &sftpConn{w: w, r: r}
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	handle, err := c.open(path)
+	if err != nil {
+		return nil, fmt.Errorf("sftp open %s: %w", path, err)
+	}
+
+	data, err := c.readAll(handle)
+	closeErr := c.close(handle)
+	if err != nil {
+		return nil, fmt.Errorf("sftp read %s: %w", path, err)
+	}
+	if closeErr != nil {
+		return nil, fmt.Errorf("sftp close %s: %w", path, closeErr)
+	}
+
+	return data, nil
+}
+
+// init negotiates protocol version 3
+func (c *sftpConn) init() error {
+	if err := c.send(sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return fmt.Errorf("sftp init: %w", err)
+	}
+	typ, _, err := c.recv()
+	if err != nil {
+		// A server that accepts the subsystem but cannot run it closes the channel
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errSFTPUnavailable
+		}
+		return fmt.Errorf("sftp init: %w", err)
+	}
+	if typ != sftpVersion {
+		return fmt.Errorf("sftp init: unexpected packet type %d", typ)
+	}
+	return nil
+}
+
+func (c *sftpConn) open(path string) ([]byte, error) {
+	payload := appendSFTPString(nil, []byte(path))
+	payload = binary.BigEndian.AppendUint32(payload, sftpOpenRead)
+	payload = binary.BigEndian.AppendUint32(payload, 0) // no attributes
+
+	typ, resp, err := c.request(sftpOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case sftpHandle:
+		handle, _, ok := parseSFTPString(resp)
+		if !ok {
+			return nil, fmt.Errorf("malformed handle")
+		}
+		return handle, nil
+	case sftpStatus:
+		return nil, sftpStatusError(resp)
+	}
+	return nil, fmt.Errorf("unexpected packet type %d", typ)
+}
+
+func (c *sftpConn) readAll(handle []byte) ([]byte, error) {
+	var data []byte
+	for {
+		payload := appendSFTPString(nil, handle)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(len(data)))
+		payload = binary.BigEndian.AppendUint32(payload, sftpReadSize)
+
+		typ, resp, err := c.request(sftpRead, payload)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case sftpData:
+			chunk, _, ok := parseSFTPString(resp)
+			if !ok {
+				return nil, fmt.Errorf("malformed data")
+			}
+			if len(chunk) == 0 {
+				return data, nil
+			}
+			if len(data)+len(chunk) > maxFileSize {
+				return nil, fmt.Errorf("file too large: over %d bytes", maxFileSize)
+			}
+			data = append(data, chunk...)
+		case sftpStatus:
+			if len(resp) >= 4 && binary.BigEndian.Uint32(resp) == sftpStatusEOF {
+				return data, nil
+			}
+			return nil, sftpStatusError(resp)
+		default:
+			return nil, fmt.Errorf("unexpected packet type %d", typ)
+		}
+	}
+}
+
+func (c *sftpConn) close(handle []byte) error {
+	typ, resp, err := c.request(sftpClose, appendSFTPString(nil, handle))
+	if err != nil {
+		return err
+	}
+	if typ != sftpStatus {
+		return fmt.Errorf("unexpected packet type %d", typ)
+	}
+	if len(resp) >= 4 && binary.BigEndian.Uint32(resp) == sftpStatusOK {
+		return nil
+	}
+	return sftpStatusError(resp)
+}
+
+// request sends a packet with a fresh request id and returns the response
+// payload after the id
+func (c *sftpConn) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.id++
+	if err := c.send(typ, append(binary.BigEndian.AppendUint32(nil, c.id), payload...)); err != nil {
+		return 0, nil, err
+	}
+
+	respType, resp, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != c.id {
+		return 0, nil, fmt.Errorf("response id mismatch")
+	}
+	return respType, resp[4:], nil
+}
+
+func (c *sftpConn) send(typ byte, payload []byte) error {
+	pkt := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	pkt = append(pkt, typ)
+	pkt = append(pkt, payload...)
+	_, err := c.w.Write(pkt)
+	return err
+}
+
+func (c *sftpConn) recv() (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[:4])
+	if length < 1 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("invalid packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], payload, nil
+}
+
+// sftpStatusError describes a status response (code, message, language)
+func sftpStatusError(resp []byte) error {
+	if len(resp) < 4 {
+		return fmt.Errorf("malformed status")
+	}
+	code := binary.BigEndian.Uint32(resp)
+	if msg, _, ok := parseSFTPString(resp[4:]); ok && len(msg) > 0 {
+		return fmt.Errorf("%s", msg)
+	}
+	if name, ok := sftpStatusNames[code]; ok {
+		return fmt.Errorf("%s", name)
+	}
+	return fmt.Errorf("status %d", code)
+}
+
+func appendSFTPString(b, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func parseSFTPString(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// sftpHandler answers a request of type typ; payload follows the request id.
+// It returns the response type and payload, which request ids are added to.
+type sftpHandler func(typ byte, payload []byte) (byte, []byte)
+
+// newSFTPTest connects an sftpConn to a server answering with handler.
+// The version exchange is answered by the server itself.
+func newSFTPTest(t *testing.T, handler sftpHandler) *sftpConn {
+	t.Helper()
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	t.Cleanup(func() {
+		clientW.Close()
+		clientR.Close()
+	})
+
+	go func() {
+		defer serverW.Close()
+		for {
+			var hdr [5]byte
+			if _, err := io.ReadFull(serverR, hdr[:]); err != nil {
+				return
+			}
+			payload := make([]byte, binary.BigEndian.Uint32(hdr[:4])-1)
+			if _, err := io.ReadFull(serverR, payload); err != nil {
+				return
+			}
+
+			var pkt []byte
+			if hdr[4] == sftpInit {
+				pkt = sftpPacket(sftpVersion, binary.BigEndian.AppendUint32(nil, 3))
+			} else {
+				typ, resp := handler(hdr[4], payload[4:])
+				pkt = sftpPacket(typ, append(payload[:4:4], resp...))
+			}
+			if _, err := serverW.Write(pkt); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := &sftpConn{w: clientW, r: clientR}
+	if err := c.init(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func sftpPacket(typ byte, payload []byte) []byte {
+	pkt := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	return append(append(pkt, typ), payload...)
+}
+
+// sftpStatusPayload builds a status response
+func sftpStatusPayload(code uint32, msg string) []byte {
+	b := binary.BigEndian.AppendUint32(nil, code)
+	b = appendSFTPString(b, []byte(msg))
+	return appendSFTPString(b, nil)
+}
+
+// fileServer serves content as the file /config, in chunks of at most chunk bytes
+func fileServer(content []byte, chunk int) sftpHandler {
+	return func(typ byte, payload []byte) (byte, []byte) {
+		switch typ {
+		case sftpOpen:
+			path, _, _ := parseSFTPString(payload)
+			if string(path) != "/config" {
+				return sftpStatus, sftpStatusPayload(2, "")
+			}
+			return sftpHandle, appendSFTPString(nil, []byte("h1"))
+		case sftpRead:
+			_, rest, _ := parseSFTPString(payload)
+			offset := binary.BigEndian.Uint64(rest)
+			if offset >= uint64(len(content)) {
+				return sftpStatus, sftpStatusPayload(sftpStatusEOF, "")
+			}
+			end := min(offset+uint64(chunk), uint64(len(content)))
+			return sftpData, appendSFTPString(nil, content[offset:end])
+		case sftpClose:
+			return sftpStatus, sftpStatusPayload(sftpStatusOK, "")
+		}
+		return sftpStatus, sftpStatusPayload(8, "")
+	}
+}
+
+func TestSFTPFetch(t *testing.T) {
+	content := bytes.Repeat([]byte("interface eth0\n"), 5000)
+	c := newSFTPTest(t, fileServer(content, sftpReadSize))
+
+	handle, err := c.open("/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.readAll(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("read %d bytes, want %d", len(data), len(content))
+	}
+	if err := c.close(handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.open("/missing"); err == nil || err.Error() != "no such file" {
+		t.Errorf("open missing file: err = %v, want no such file", err)
+	}
+}
+
+func TestSFTPReadError(t *testing.T) {
+	c := newSFTPTest(t, func(typ byte, payload []byte) (byte, []byte) {
+		if typ == sftpOpen {
+			return sftpHandle, appendSFTPString(nil, []byte("h1"))
+		}
+		return sftpStatus, sftpStatusPayload(4, "i/o error")
+	})
+
+	handle, err := c.open("/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.readAll(handle); err == nil || err.Error() != "i/o error" {
+		t.Errorf("err = %v, want i/o error", err)
+	}
+}
+
+func TestSFTPFileTooLarge(t *testing.T) {
+	chunk := sftpMaxPacket - 64
+	c := newSFTPTest(t, func(typ byte, payload []byte) (byte, []byte) {
+		if typ == sftpOpen {
+			return sftpHandle, appendSFTPString(nil, []byte("h1"))
+		}
+		// An endless file
+		return sftpData, appendSFTPString(nil, make([]byte, chunk))
+	})
+
+	handle, err := c.open("/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.readAll(handle); err == nil || !strings.Contains(err.Error(), "file too large") {
+		t.Errorf("err = %v, want file too large", err)
+	}
+}
+
+func TestSFTPResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  byte
+		resp []byte
+		err  string
+	}{
+		{name: "status with message", typ: sftpStatus, resp: sftpStatusPayload(3, "denied by policy"), err: "denied by policy"},
+		{name: "status without message", typ: sftpStatus, resp: sftpStatusPayload(3, ""), err: "permission denied"},
+		{name: "unknown status", typ: sftpStatus, resp: sftpStatusPayload(42, ""), err: "status 42"},
+		{name: "status code only", typ: sftpStatus, resp: binary.BigEndian.AppendUint32(nil, 4), err: "failure"},
+		{name: "truncated status", typ: sftpStatus, resp: []byte{0, 0}, err: "malformed status"},
+		{name: "truncated handle length", typ: sftpHandle, resp: []byte{0, 0}, err: "malformed handle"},
+		{name: "handle longer than packet", typ: sftpHandle, resp: []byte{0, 0, 0, 9, 'h'}, err: "malformed handle"},
+		{name: "unexpected type", typ: sftpData, resp: appendSFTPString(nil, nil), err: "unexpected packet type 103"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSFTPTest(t, func(byte, []byte) (byte, []byte) { return tt.typ, tt.resp })
+			_, err := c.open("/config")
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSFTPRecv(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		err   string
+	}{
+		{name: "truncated header", input: []byte{0, 0, 0}, err: "unexpected EOF"},
+		{name: "no header", input: nil, err: "EOF"},
+		{name: "zero length", input: []byte{0, 0, 0, 0, sftpData}, err: "invalid packet length 0"},
+		{name: "oversize length", input: []byte{0xff, 0xff, 0xff, 0xff, sftpData}, err: "invalid packet length 4294967295"},
+		{name: "just over the limit", input: append(binary.BigEndian.AppendUint32(nil, sftpMaxPacket+1), sftpData), err: "invalid packet length"},
+		{name: "truncated payload", input: []byte{0, 0, 0, 9, sftpData, 0, 0}, err: "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &sftpConn{r: bytes.NewReader(tt.input)}
+			_, _, err := c.recv()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSFTPRequestIDMismatch(t *testing.T) {
+	var resp bytes.Buffer
+	resp.Write(sftpPacket(sftpStatus, append(binary.BigEndian.AppendUint32(nil, 7), sftpStatusPayload(0, "")...)))
+	c := &sftpConn{w: nopWriteCloser{io.Discard}, r: &resp}
+
+	if _, _, err := c.request(sftpClose, nil); err == nil || err.Error() != "response id mismatch" {
+		t.Errorf("err = %v, want response id mismatch", err)
+	}
+}
+
+func TestSFTPInitUnavailable(t *testing.T) {
+	c := &sftpConn{w: nopWriteCloser{io.Discard}, r: bytes.NewReader(nil)}
+	if err := c.init(); !errors.Is(err, errSFTPUnavailable) {
+		t.Errorf("err = %v, want errSFTPUnavailable", err)
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -1,72 +1,67 @@
 package transport
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zinrai/netback/config"
 	"golang.org/x/crypto/ssh"
 )
 
+func init() {
+	Register(config.TransportSSH, func(device *config.Device, model *config.Model, opts *Options) Transport {
+		return NewSSHClient(device, model, opts)
+	})
+}
+
 // SSHClient manages an SSH connection to a device
 type SSHClient struct {
 	device  *config.Device
 	model   *config.Model
+	opts    *Options
 	client  *ssh.Client
 	session *ssh.Session
+	shell   *Session
 }
 
 // NewSSHClient creates a new SSH client for the device
-func NewSSHClient(device *config.Device, model *config.Model) *SSHClient {
+func NewSSHClient(device *config.Device, model *config.Model, opts *Options) *SSHClient {
 	return &SSHClient{
 		device: device,
 		model:  model,
+		opts:   opts,
 	}
 }
 
-// Connect establishes the SSH connection
-func (c *SSHClient) Connect() (*Session, error) {
-	log.Printf("%s: connecting...", c.device.Name)
-
-	sshConfig := &ssh.ClientConfig{
-		User: c.device.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(c.device.Password),
-			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
-				answers := make([]string, len(questions))
-				for i := range questions {
-					answers[i] = c.device.Password
-				}
-				return answers, nil
-			}),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         c.device.EffectiveTimeout(),
+// Connect establishes the SSH connection. The interactive shell is only
+// started when the model has commands in shell mode; exec mode and file
+// retrieval need just the client.
+func (c *SSHClient) Connect(ctx context.Context) error {
+	useShell := c.model.HasCommands() && c.model.Mode != config.ModeExec
+	if useShell && c.model.Prompt == "" {
+		return fmt.Errorf("model has no prompt")
 	}
 
-	addr := net.JoinHostPort(c.device.IP, strconv.Itoa(c.device.EffectivePort()))
-
-	conn, err := net.DialTimeout("tcp", addr, c.device.EffectiveTimeout())
+	client, err := dialSSH(ctx, c.device, c.model, c.opts)
 	if err != nil {
-		return nil, fmt.Errorf("dial %s: %w", addr, err)
+		return err
 	}
+	c.client = client
 
-	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("ssh handshake: %w", err)
+	if !useShell {
+		return nil
 	}
 
-	c.client = ssh.NewClient(sshConn, chans, reqs)
-	log.Printf("%s: ssh connected", c.device.Name)
-
 	c.session, err = c.client.NewSession()
 	if err != nil {
 		c.client.Close()
-		return nil, fmt.Errorf("new session: %w", err)
+		return fmt.Errorf("new session: %w", err)
 	}
 
 	// Request pseudo-terminal
@@ -76,106 +71,272 @@ func (c *SSHClient) Connect() (*Session, error) {
 		ssh.TTY_OP_OSPEED: 14400,
 	}
 
-	if err := c.session.RequestPty("xterm", 80, 200, modes); err != nil {
+	width, height := c.model.Terminal.EffectiveSize()
+	if err := c.session.RequestPty(c.model.Terminal.EffectiveType(), height, width, modes); err != nil {
 		c.Close()
-		return nil, fmt.Errorf("request pty: %w", err)
+		return fmt.Errorf("request pty: %w", err)
 	}
 
 	stdin, err := c.session.StdinPipe()
 	if err != nil {
 		c.Close()
-		return nil, fmt.Errorf("stdin pipe: %w", err)
+		return fmt.Errorf("stdin pipe: %w", err)
 	}
 
 	stdout, err := c.session.StdoutPipe()
 	if err != nil {
 		c.Close()
-		return nil, fmt.Errorf("stdout pipe: %w", err)
+		return fmt.Errorf("stdout pipe: %w", err)
 	}
 
 	if err := c.session.Shell(); err != nil {
 		c.Close()
-		return nil, fmt.Errorf("start shell: %w", err)
+		return fmt.Errorf("start shell: %w", err)
 	}
 
 	session := NewSession(stdin, stdout, c.model, c.device.EffectiveTimeout())
+	session.recorder = c.opts.Recorder
 
 	// Wait for initial prompt
-	log.Printf("%s: waiting for prompt...", c.device.Name)
-	if _, err := session.ReadUntilPrompt(); err != nil {
+	c.opts.logger().Debug("waiting for prompt", "phase", PhaseConnect)
+	if _, err := session.ReadUntilPrompt(ctx); err != nil {
 		c.Close()
-		return nil, fmt.Errorf("wait for initial prompt: %w", err)
+		return fmt.Errorf("wait for initial prompt: %w", withPhase(err, PhaseConnect, ""))
 	}
 
 	// Execute post-login commands
-	log.Printf("%s: executing post_login...", c.device.Name)
-	if err := session.ExecutePostLogin(); err != nil {
+	c.opts.logger().Debug("executing post_login", "phase", PhasePostLogin)
+	if err := session.ExecutePostLogin(ctx, c.device.EnablePassword); err != nil {
 		c.Close()
-		return nil, fmt.Errorf("post-login: %w", err)
+		return fmt.Errorf("post-login: %w", err)
 	}
 
-	return session, nil
+	c.shell = session
+	return nil
 }
 
-// Close closes the SSH connection
-func (c *SSHClient) Close() error {
-	var errs []error
+// dialSSH connects and authenticates to the device, returning the SSH client.
+// Dialing and the handshake together are bounded by the device timeout.
+func dialSSH(ctx context.Context, device *config.Device, model *config.Model, opts *Options) (*ssh.Client, error) {
+	logger := opts.logger()
+	logger.Info("connecting", "phase", PhaseConnect)
 
-	if c.session != nil {
-		if err := c.session.Close(); err != nil {
-			errs = append(errs, err)
+	addr := net.JoinHostPort(device.IP, strconv.Itoa(device.EffectivePort()))
+
+	auth, closeAuth, err := authMethods(&device.Credentials)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	defer closeAuth()
+
+	algos := device.EffectiveSSH(model)
+
+	// Keys on record narrow the host key algorithms unless they are set explicitly
+	hostKeyAlgos := algos.HostKeyAlgorithms
+	if hostKeyAlgos == nil {
+		hostKeyAlgos = opts.Dialer.hostKeys.HostKeyAlgorithms(&device.HostKeyConfig, addr)
+	}
+	algos = algos.Resolve()
+	if hostKeyAlgos == nil {
+		hostKeyAlgos = algos.HostKeyAlgorithms
+	}
+
+	sshConfig := &ssh.ClientConfig{
+		Config: ssh.Config{
+			KeyExchanges: algos.KeyExchanges,
+			Ciphers:      algos.Ciphers,
+			MACs:         algos.MACs,
+		},
+		User:              device.Username,
+		Auth:              auth,
+		HostKeyCallback:   opts.Dialer.hostKeys.Callback(logger, &device.HostKeyConfig),
+		HostKeyAlgorithms: hostKeyAlgos,
+		Timeout:           device.EffectiveTimeout(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, device.EffectiveTimeout())
+	defer cancel()
+
+	conn, err := opts.Dialer.Dial(ctx, logger, device, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConn, chans, reqs, err := handshake(ctx, conn, addr, sshConfig)
+	if err != nil {
+		var negErr *ssh.AlgorithmNegotiationError
+		if errors.As(err, &negErr) {
+			return nil, fmt.Errorf("ssh handshake: no common algorithm for %s; server offered: %s (adjust the ssh settings or use preset: legacy)",
+				negErr.What, strings.Join(offeredAlgorithms(negErr.RequestedAlgorithms), ", "))
 		}
+		err = fmt.Errorf("ssh handshake: %w", err)
+		if isAuthFailure(err) {
+			return nil, &AuthError{Phase: PhaseConnect, Err: err}
+		}
+		return nil, err
 	}
 
-	if c.client != nil {
-		if err := c.client.Close(); err != nil {
-			errs = append(errs, err)
+	logger.Debug("ssh connected", "phase", PhaseConnect)
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// handshake runs the SSH client handshake on conn, which ssh.NewClientConn
+// does not bound by any deadline, closing conn if ctx is done first.
+// conn is closed on failure.
+func handshake(ctx context.Context, conn net.Conn, addr string, cfg *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	stop := closeOnDone(ctx, conn, "during handshake")
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	if ctxErr := stop(); ctxErr != nil {
+		if err == nil {
+			sshConn.Close()
 		}
+		return nil, nil, nil, ctxErr
 	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	return sshConn, chans, reqs, nil
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("close errors: %v", errs)
+// offeredAlgorithms drops the extension markers servers list among key exchanges
+func offeredAlgorithms(algos []string) []string {
+	var offered []string
+	for _, a := range algos {
+		if strings.HasPrefix(a, "kex-strict-") || strings.HasPrefix(a, "ext-info-") {
+			continue
+		}
+		offered = append(offered, a)
 	}
-	return nil
+	return offered
+}
+
+// Run executes a command in the shell, or on its own exec channel in
+// exec mode, and returns its raw output
+func (c *SSHClient) Run(ctx context.Context, cmd *config.Command) (string, error) {
+	if c.model.Mode == config.ModeExec {
+		return c.exec(ctx, cmd.Cmd, cmd.EffectiveTimeout(c.device.EffectiveTimeout()))
+	}
+	if c.shell == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	return c.shell.Run(ctx, cmd)
 }
 
-// ConnectAndExecute connects, executes all commands, and returns outputs per command
-func ConnectAndExecute(device *config.Device, model *config.Model) ([]string, []string, error) {
-	client := NewSSHClient(device, model)
+// exec runs cmd on a fresh session without a PTY, for at most timeout.
+// A non-zero exit status is a command failure; servers that send no exit
+// status are trusted. On timeout only the session is closed, so later
+// commands can still run.
+func (c *SSHClient) exec(ctx context.Context, cmd string, timeout time.Duration) (string, error) {
+	if c.client == nil {
+		return "", fmt.Errorf("not connected")
+	}
 
-	session, err := client.Connect()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	stop := closeOnDone(ctx, c.client, "opening session")
+	session, err := c.client.NewSession()
+	if ctxErr := stop(); ctxErr != nil {
+		return "", &SessionLost{Err: ctxErr}
+	}
 	if err != nil {
-		return nil, nil, err
+		return "", &SessionLost{Err: fmt.Errorf("new session: %w", err)}
 	}
-	defer client.Close()
+	defer session.Close()
+	stop = closeOnDone(ctx, session, "waiting for command output")
 
-	// Execute comment commands (each output stored separately)
-	log.Printf("%s: executing comments...", device.Name)
-	commentsOutputs := make([]string, 0, len(model.Comments))
-	for _, cmd := range model.Comments {
-		result, err := session.Execute(cmd)
-		if err != nil {
-			return nil, nil, fmt.Errorf("execute comment %q: %w", cmd, err)
-		}
-		commentsOutputs = append(commentsOutputs, result)
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+
+	out, err := session.Output(cmd)
+	if ctxErr := stop(); ctxErr != nil {
+		return "", ctxErr
 	}
 
-	// Execute config commands (each output stored separately)
-	log.Printf("%s: executing commands...", device.Name)
-	commandsOutputs := make([]string, 0, len(model.Commands))
-	for _, cmd := range model.Commands {
-		result, err := session.Execute(cmd)
-		if err != nil {
-			return commentsOutputs, nil, fmt.Errorf("execute %q: %w", cmd, err)
+	var exitErr *ssh.ExitError
+	var missingErr *ssh.ExitMissingError
+	switch {
+	case errors.As(err, &exitErr):
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = lastLine(string(out))
 		}
-		commandsOutputs = append(commandsOutputs, result)
+		if msg == "" {
+			return "", fmt.Errorf("exit status %d", exitErr.ExitStatus())
+		}
+		return "", fmt.Errorf("exit status %d: %s", exitErr.ExitStatus(), msg)
+	case errors.As(err, &missingErr):
+	case err != nil:
+		return "", fmt.Errorf("exec: %w", err)
+	}
+
+	return string(out), nil
+}
+
+// lastLine returns the last non-empty line of s
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// Framed reports false in exec mode, where output has no echo or prompt
+func (c *SSHClient) Framed() bool {
+	return c.model.Mode != config.ModeExec
+}
+
+// maxFileSize bounds a file retrieved over SFTP or SCP, so that a broken or
+// hostile server cannot exhaust memory
+const maxFileSize = 64 * 1024 * 1024
+
+// FetchFile retrieves a file over SFTP, falling back to SCP when the
+// server has no SFTP subsystem. The transfer is bounded by the device timeout.
+func (c *SSHClient) FetchFile(ctx context.Context, path string) ([]byte, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.device.EffectiveTimeout())
+	defer cancel()
+	stop := closeOnDone(ctx, c.client, "retrieving file")
+
+	data, err := fetchSFTP(c.client, path)
+	if errors.Is(err, errSFTPUnavailable) {
+		c.opts.logger().Info("sftp unavailable, falling back to scp", "path", path)
+		data, err = fetchSCP(c.client, path)
+	}
+	if ctxErr := stop(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return data, err
+}
+
+// Close logs out and closes the SSH connection
+func (c *SSHClient) Close() error {
+	if c.shell != nil {
+		// Send pre-logout command (best effort)
+		_ = c.shell.ExecutePreLogout()
+
+		// Small delay to allow graceful disconnect
+		time.Sleep(100 * time.Millisecond)
+		c.shell = nil
 	}
 
-	// Send pre-logout command (best effort)
-	_ = session.ExecutePreLogout()
+	var errs []error
+
+	if c.session != nil {
+		if err := c.session.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-	// Small delay to allow graceful disconnect
-	time.Sleep(100 * time.Millisecond)
+	if c.client != nil {
+		if err := c.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-	return commentsOutputs, commandsOutputs, nil
+	if len(errs) > 0 {
+		return fmt.Errorf("close errors: %v", errs)
+	}
+	return nil
 }
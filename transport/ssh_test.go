@@ -0,0 +1,287 @@
+package transport
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zinrai/netback/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServerKey returns a new host key as a signer and in authorized_keys format
+func sshServerKey(t *testing.T) (ssh.Signer, string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+}
+
+// sshServer accepts SSH connections for user backup with password pw and
+// returns its address
+func sshServer(t *testing.T, hostKey ssh.Signer, pw string) (string, int) {
+	t.Helper()
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "backup" && string(password) == pw {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", c.User())
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	return serveSSH(t, cfg, nil)
+}
+
+// serveSSH accepts SSH connections with cfg, keeping each open until the
+// client closes it, and returns the server's address. Channels are passed
+// to handle, or rejected if it is nil.
+func serveSSH(t *testing.T, cfg *ssh.ServerConfig, handle func(ssh.NewChannel)) (string, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sconn, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+				defer sconn.Close()
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					if handle == nil {
+						ch.Reject(ssh.Prohibited, "no channels")
+						continue
+					}
+					go handle(ch)
+				}
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func TestDialSSHErrors(t *testing.T) {
+	hostKey, pinned := sshServerKey(t)
+	_, otherKey := sshServerKey(t)
+	host, port := sshServer(t, hostKey, "secret")
+
+	// A port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	tests := []struct {
+		name     string
+		password string
+		hostKey  string
+		port     int
+		// want describes the error check accepts
+		want  string
+		check func(err error) bool
+	}{
+		{
+			name:     "accepted",
+			password: "secret",
+			hostKey:  pinned,
+			port:     port,
+		},
+		{
+			name:     "password rejected",
+			password: "wrong",
+			hostKey:  pinned,
+			port:     port,
+			want:     "AuthError",
+			check: func(err error) bool {
+				var e *AuthError
+				return errors.As(err, &e) && e.Phase == PhaseConnect && isAuthFailure(err)
+			},
+		},
+		{
+			name:     "host key mismatch",
+			password: "secret",
+			hostKey:  otherKey,
+			port:     port,
+			want:     "HostKeyError",
+			check: func(err error) bool {
+				var e *HostKeyError
+				var authErr *AuthError
+				return errors.As(err, &e) && e.Phase == PhaseConnect && !errors.As(err, &authErr)
+			},
+		},
+		{
+			name:     "unreachable",
+			password: "secret",
+			hostKey:  pinned,
+			port:     closedPort,
+			want:     "DialError",
+			check: func(err error) bool {
+				var e *DialError
+				return errors.As(err, &e) && e.Phase == PhaseConnect
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := &config.Device{
+				Name:    "r1",
+				IP:      host,
+				Port:    tt.port,
+				Timeout: 5 * time.Second,
+			}
+			device.Username = "backup"
+			device.Password = tt.password
+			device.HostKey = tt.hostKey
+			opts := &Options{Dialer: NewDialer(nil, nil), Logger: discardLogger}
+
+			client, err := dialSSH(context.Background(), device, &config.Model{}, opts)
+			if tt.check == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.Close()
+				return
+			}
+			if err == nil {
+				client.Close()
+				t.Fatal("expected an error")
+			}
+			if !tt.check(err) {
+				t.Errorf("err = %v (%T), want a %s in the connect phase", err, err, tt.want)
+			}
+		})
+	}
+}
+
+// execReply is how the exec server answers a command
+type execReply struct {
+	stdout, stderr string
+	// status is the exit status sent; negative sends none
+	status int
+}
+
+// execHandler runs exec requests on session channels, answering each
+// command from replies
+func execHandler(replies map[string]execReply) func(ssh.NewChannel) {
+	return func(nc ssh.NewChannel) {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			return
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+		for req := range reqs {
+			if req.Type != "exec" {
+				req.Reply(false, nil)
+				continue
+			}
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				return
+			}
+			req.Reply(true, nil)
+
+			reply := replies[payload.Command]
+			io.WriteString(ch, reply.stdout)
+			io.WriteString(ch.Stderr(), reply.stderr)
+			if reply.status >= 0 {
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(reply.status)}))
+			}
+			return
+		}
+	}
+}
+
+func TestExecExitStatus(t *testing.T) {
+	hostKey, pinned := sshServerKey(t)
+	replies := map[string]execReply{
+		"show running-config": {stdout: "hostname r1\n", status: 0},
+		"show bogus":          {stderr: "% Invalid input\n", status: 1},
+		"show broken":         {stdout: "partial\nerror: bad request\n", status: 2},
+		"show nothing":        {status: 3},
+		"show version":        {stdout: "version 1.0\n", status: -1},
+	}
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(hostKey)
+	host, port := serveSSH(t, cfg, execHandler(replies))
+
+	device := &config.Device{Name: "r1", IP: host, Port: port, Timeout: 5 * time.Second}
+	device.Username = "backup"
+	device.Password = "secret"
+	device.HostKey = pinned
+	model := &config.Model{Mode: config.ModeExec, Commands: []config.Command{{Cmd: "show running-config"}}}
+	c := NewSSHClient(device, model, &Options{Dialer: NewDialer(nil, nil), Logger: discardLogger})
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	tests := []struct {
+		cmd  string
+		want string
+		err  string
+	}{
+		{cmd: "show running-config", want: "hostname r1\n"},
+		{cmd: "show bogus", err: "exit status 1: % Invalid input"},
+		{cmd: "show broken", err: "exit status 2: error: bad request"},
+		{cmd: "show nothing", err: "exit status 3"},
+		// Servers that send no exit status are trusted
+		{cmd: "show version", want: "version 1.0\n"},
+	}
+
+	// The commands share the connection, so a failed one leaves it usable
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			out, err := c.Run(context.Background(), &config.Command{Cmd: tt.cmd})
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if out != tt.want {
+					t.Errorf("output = %q, want %q", out, tt.want)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+			if errors.As(err, new(*SessionLost)) {
+				t.Errorf("err = %v, want the session kept", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zinrai/netback/config"
+)
+
+// Telnet commands (RFC 854)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet options
+const (
+	telnetOptEcho  = 1  // RFC 857
+	telnetOptSGA   = 3  // RFC 858
+	telnetOptTType = 24 // RFC 1091
+	telnetOptNAWS  = 31 // RFC 1073
+)
+
+// Telnet terminal type subnegotiation codes (RFC 1091)
+const (
+	telnetTTypeIS   = 0
+	telnetTTypeSend = 1
+)
+
+func init() {
+	Register(config.TransportTelnet, func(device *config.Device, model *config.Model, opts *Options) Transport {
+		return NewTelnetClient(device, model, opts)
+	})
+}
+
+// TelnetClient manages a Telnet connection to a device
+type TelnetClient struct {
+	device *config.Device
+	model  *config.Model
+	opts   *Options
+	conn   net.Conn
+	shell  *Session
+}
+
+// NewTelnetClient creates a new Telnet client for the device
+func NewTelnetClient(device *config.Device, model *config.Model, opts *Options) *TelnetClient {
+	return &TelnetClient{
+		device: device,
+		model:  model,
+		opts:   opts,
+	}
+}
+
+// Connect establishes the Telnet connection and logs in
+func (c *TelnetClient) Connect(ctx context.Context) error {
+	if c.model.Prompt == "" {
+		return fmt.Errorf("model has no prompt")
+	}
+
+	logger := c.opts.logger()
+	logger.Info("connecting", "phase", PhaseConnect)
+
+	addr := net.JoinHostPort(c.device.IP, strconv.Itoa(c.device.EffectivePort()))
+
+	conn, err := c.opts.Dialer.Dial(ctx, logger, c.device, addr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	logger.Debug("telnet connected", "phase", PhaseConnect)
+
+	width, height := c.model.Terminal.EffectiveSize()
+	tc := newTelnetConn(conn, c.model.Terminal.EffectiveType(), width, height)
+	session := NewSession(tc, tc, c.model, c.device.EffectiveTimeout())
+	session.recorder = c.opts.Recorder
+
+	logger.Debug("logging in", "phase", PhaseLogin)
+	if err := session.Login(ctx, c.device.Username, c.device.Password); err != nil {
+		c.Close()
+		return fmt.Errorf("login: %w", err)
+	}
+
+	logger.Debug("executing post_login", "phase", PhasePostLogin)
+	if err := session.ExecutePostLogin(ctx, c.device.EnablePassword); err != nil {
+		c.Close()
+		return fmt.Errorf("post-login: %w", err)
+	}
+
+	c.shell = session
+	return nil
+}
+
+// Run executes a command and returns its raw output
+func (c *TelnetClient) Run(ctx context.Context, cmd *config.Command) (string, error) {
+	if c.shell == nil {
+		return "", fmt.Errorf("not connected")
+	}
+	return c.shell.Run(ctx, cmd)
+}
+
+// Close logs out and closes the Telnet connection
+func (c *TelnetClient) Close() error {
+	if c.shell != nil {
+		// Send pre-logout command (best effort)
+		_ = c.shell.ExecutePreLogout()
+
+		// Small delay to allow graceful disconnect
+		time.Sleep(100 * time.Millisecond)
+		c.shell = nil
+	}
+
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+// telnetConn strips Telnet protocol sequences from the data stream,
+// answers option negotiation, and escapes outgoing data
+type telnetConn struct {
+	conn net.Conn
+	wmu  sync.Mutex
+
+	// Terminal advertised to the device, as for the SSH PTY request
+	termType string
+	width    int
+	height   int
+
+	// Parser state, carried across reads
+	state int
+	cmd   byte
+	sb    []byte
+}
+
+// Parser states
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+	telnetStateCR
+)
+
+func newTelnetConn(conn net.Conn, termType string, width, height int) *telnetConn {
+	return &telnetConn{conn: conn, termType: termType, width: width, height: height}
+}
+
+// Read returns application data with Telnet sequences removed
+func (t *telnetConn) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for {
+		n, err := t.conn.Read(buf)
+		out := t.parse(buf[:n], p[:0])
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// parse consumes raw bytes, appending data bytes to out
+func (t *telnetConn) parse(in, out []byte) []byte {
+	for _, b := range in {
+		switch t.state {
+		case telnetStateData, telnetStateCR:
+			// CR NUL is a bare carriage return (RFC 854)
+			if t.state == telnetStateCR && b == 0 {
+				t.state = telnetStateData
+				continue
+			}
+			t.state = telnetStateData
+			switch b {
+			case telnetIAC:
+				t.state = telnetStateIAC
+			case '\r':
+				out = append(out, b)
+				t.state = telnetStateCR
+			default:
+				out = append(out, b)
+			}
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC:
+				out = append(out, b)
+				t.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.cmd = b
+				t.state = telnetStateOption
+			case telnetSB:
+				t.sb = t.sb[:0]
+				t.state = telnetStateSB
+			default:
+				// NOP, GA and other commands carry no data
+				t.state = telnetStateData
+			}
+		case telnetStateOption:
+			t.negotiate(t.cmd, b)
+			t.state = telnetStateData
+		case telnetStateSB:
+			if b == telnetIAC {
+				t.state = telnetStateSBIAC
+			} else {
+				t.sb = append(t.sb, b)
+			}
+		case telnetStateSBIAC:
+			switch b {
+			case telnetSE:
+				t.subnegotiate(t.sb)
+				t.state = telnetStateData
+			case telnetIAC:
+				t.sb = append(t.sb, b)
+				t.state = telnetStateSB
+			default:
+				t.state = telnetStateSB
+			}
+		}
+	}
+	return out
+}
+
+// negotiate answers a WILL/WONT/DO/DONT request
+func (t *telnetConn) negotiate(cmd, opt byte) {
+	switch cmd {
+	case telnetWILL:
+		// Let the device echo and suppress go-ahead; refuse anything else
+		if opt == telnetOptEcho || opt == telnetOptSGA {
+			t.sendCommand(telnetDO, opt)
+		} else {
+			t.sendCommand(telnetDONT, opt)
+		}
+	case telnetDO:
+		switch opt {
+		case telnetOptSGA, telnetOptTType:
+			t.sendCommand(telnetWILL, opt)
+		case telnetOptNAWS:
+			t.sendCommand(telnetWILL, opt)
+			size := binary.BigEndian.AppendUint16(nil, uint16(t.width))
+			size = binary.BigEndian.AppendUint16(size, uint16(t.height))
+			t.sendSubnegotiation(telnetOptNAWS, size)
+		default:
+			t.sendCommand(telnetWONT, opt)
+		}
+	}
+	// WONT and DONT need no answer
+}
+
+// subnegotiate answers a subnegotiation request
+func (t *telnetConn) subnegotiate(data []byte) {
+	if len(data) >= 2 && data[0] == telnetOptTType && data[1] == telnetTTypeSend {
+		t.sendSubnegotiation(telnetOptTType, append([]byte{telnetTTypeIS}, t.termType...))
+	}
+}
+
+// sendSubnegotiation sends IAC SB opt data IAC SE, doubling IAC bytes in data
+// (a width or height of 255 in NAWS, for example)
+func (t *telnetConn) sendSubnegotiation(opt byte, data []byte) {
+	msg := []byte{telnetIAC, telnetSB, opt}
+	for _, b := range data {
+		if b == telnetIAC {
+			msg = append(msg, telnetIAC)
+		}
+		msg = append(msg, b)
+	}
+	t.sendRaw(append(msg, telnetIAC, telnetSE))
+}
+
+func (t *telnetConn) sendCommand(cmd, opt byte) {
+	t.sendRaw([]byte{telnetIAC, cmd, opt})
+}
+
+func (t *telnetConn) sendRaw(data []byte) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	// Negotiation is best effort; write errors surface on the next data write
+	_, _ = t.conn.Write(data)
+}
+
+// Write escapes IAC bytes and converts line endings to CR LF
+func (t *telnetConn) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	for i, b := range p {
+		switch {
+		case b == telnetIAC:
+			buf.WriteByte(telnetIAC)
+			buf.WriteByte(telnetIAC)
+		case b == '\n' && (i == 0 || p[i-1] != '\r'):
+			buf.WriteString("\r\n")
+		default:
+			buf.WriteByte(b)
+		}
+	}
+
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if _, err := t.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
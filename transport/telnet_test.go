@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// sentConn records what is written to it
+type sentConn struct {
+	net.Conn
+	sent bytes.Buffer
+}
+
+func (c *sentConn) Write(p []byte) (int, error) {
+	return c.sent.Write(p)
+}
+
+// negotiate feeds in to a telnetConn for terminal termType of width x
+// height and returns the data read and what it sent back
+func negotiate(t *testing.T, termType string, width, height int, in []byte) (string, []byte) {
+	t.Helper()
+	conn := &sentConn{}
+	tc := newTelnetConn(conn, termType, width, height)
+	data := string(tc.parse(in, nil))
+	return data, conn.sent.Bytes()
+}
+
+func TestTelnetNAWS(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		want          []byte
+	}{
+		{
+			name: "default", width: 200, height: 80,
+			want: []byte{telnetIAC, telnetWILL, telnetOptNAWS, telnetIAC, telnetSB, telnetOptNAWS, 0, 200, 0, 80, telnetIAC, telnetSE},
+		},
+		{
+			name: "wide", width: 512, height: 100,
+			want: []byte{telnetIAC, telnetWILL, telnetOptNAWS, telnetIAC, telnetSB, telnetOptNAWS, 2, 0, 0, 100, telnetIAC, telnetSE},
+		},
+		{
+			name: "height 255", width: 80, height: 255,
+			want: []byte{telnetIAC, telnetWILL, telnetOptNAWS, telnetIAC, telnetSB, telnetOptNAWS, 0, 80, 0, 255, 255, telnetIAC, telnetSE},
+		},
+		{
+			name: "255 escaped", width: 255, height: 0xff00,
+			want: []byte{telnetIAC, telnetWILL, telnetOptNAWS, telnetIAC, telnetSB, telnetOptNAWS, 0, 255, 255, 255, 255, 0, telnetIAC, telnetSE},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, sent := negotiate(t, "xterm", tt.width, tt.height, []byte{telnetIAC, telnetDO, telnetOptNAWS})
+			if !bytes.Equal(sent, tt.want) {
+				t.Errorf("sent %v, want %v", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelnetTerminalType(t *testing.T) {
+	is := func(termType string) []byte {
+		msg := append([]byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeIS}, termType...)
+		return append(msg, telnetIAC, telnetSE)
+	}
+	send := []byte{telnetIAC, telnetSB, telnetOptTType, telnetTTypeSend, telnetIAC, telnetSE}
+
+	tests := []struct {
+		name     string
+		termType string
+		// reads are fed to the connection one after the other
+		reads [][]byte
+		want  []byte
+	}{
+		{
+			name:     "send",
+			termType: "vt100",
+			reads:    [][]byte{send},
+			want:     is("vt100"),
+		},
+		{
+			name:     "split across reads",
+			termType: "xterm",
+			reads:    [][]byte{send[:2], send[2:5], send[5:]},
+			want:     is("xterm"),
+		},
+		{
+			name:     "asked twice",
+			termType: "vt100",
+			reads:    [][]byte{send, send},
+			want:     append(is("vt100"), is("vt100")...),
+		},
+		{
+			name:     "is ignored",
+			termType: "vt100",
+			reads:    [][]byte{is("xterm")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &sentConn{}
+			tc := newTelnetConn(conn, tt.termType, 200, 80)
+			var data []byte
+			for _, in := range tt.reads {
+				data = tc.parse(in, data)
+			}
+			data = tc.parse([]byte("ok"), data)
+			if !bytes.Equal(conn.sent.Bytes(), tt.want) {
+				t.Errorf("sent %v, want %v", conn.sent.Bytes(), tt.want)
+			}
+			if string(data) != "ok" {
+				t.Errorf("data = %q, want ok", data)
+			}
+		})
+	}
+}
+
+func TestTelnetNegotiate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{name: "will echo", in: []byte{telnetIAC, telnetWILL, telnetOptEcho}, want: []byte{telnetIAC, telnetDO, telnetOptEcho}},
+		{name: "will sga", in: []byte{telnetIAC, telnetWILL, telnetOptSGA}, want: []byte{telnetIAC, telnetDO, telnetOptSGA}},
+		{name: "will other", in: []byte{telnetIAC, telnetWILL, 42}, want: []byte{telnetIAC, telnetDONT, 42}},
+		{name: "do ttype", in: []byte{telnetIAC, telnetDO, telnetOptTType}, want: []byte{telnetIAC, telnetWILL, telnetOptTType}},
+		{name: "do other", in: []byte{telnetIAC, telnetDO, 42}, want: []byte{telnetIAC, telnetWONT, 42}},
+		{name: "dont", in: []byte{telnetIAC, telnetDONT, telnetOptNAWS}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, sent := negotiate(t, "xterm", 200, 80, tt.in); !bytes.Equal(sent, tt.want) {
+				t.Errorf("sent %v, want %v", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelnetParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want string
+	}{
+		{name: "escaped iac", in: []byte{'a', telnetIAC, telnetIAC, 'b'}, want: "a\xffb"},
+		{name: "cr nul", in: []byte{'a', '\r', 0, 'b'}, want: "a\rb"},
+		{name: "cr lf", in: []byte{'a', '\r', '\n'}, want: "a\r\n"},
+		{name: "wont ignored", in: []byte{telnetIAC, telnetWONT, telnetOptEcho, 'x'}, want: "x"},
+		{name: "subnegotiation skipped", in: []byte{telnetIAC, telnetSB, 99, 1, telnetIAC, telnetIAC, 2, telnetIAC, telnetSE, 'x'}, want: "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := negotiate(t, "xterm", 200, 80, tt.in); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/zinrai/netback/config"
+)
+
+// Transport is a connection to a device over a particular protocol.
+// Cancelling the context passed to Connect or Run aborts the operation;
+// the transport may then be unusable and should be closed.
+type Transport interface {
+	// Connect establishes the connection and prepares the device for commands
+	Connect(ctx context.Context) error
+	// Run executes a command and returns its raw output. The command's
+	// timeout, when set, replaces the device timeout.
+	Run(ctx context.Context, cmd *config.Command) (string, error)
+	// Close logs out (best effort) and releases the connection
+	Close() error
+}
+
+// Framer is implemented by transports that report whether command output
+// is framed by the echoed command and the prompt, as on an interactive CLI.
+// Transports that do not implement it are assumed to be framed.
+type Framer interface {
+	Framed() bool
+}
+
+// FileFetcher is implemented by transports that can retrieve files from
+// the device, for models with a files list
+type FileFetcher interface {
+	FetchFile(ctx context.Context, path string) ([]byte, error)
+}
+
+// Options holds run-wide settings shared by all transports
+type Options struct {
+	Dialer *Dialer
+	// Recorder, if set, records the traffic of interactive CLI sessions.
+	// The executor sets it for each device.
+	Recorder *Recorder
+	// Logger receives the transport's log messages. The executor sets it
+	// for each device, with the device's attributes; nil means
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// logger returns the logger to use
+func (o *Options) logger() *slog.Logger {
+	if o.Logger == nil {
+		return slog.Default()
+	}
+	return o.Logger
+}
+
+// Factory creates a transport for a device
+type Factory func(device *config.Device, model *config.Model, opts *Options) Transport
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a transport available under the given name, as selected by
+// the device's transport field. Registering a name twice replaces the factory.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Registered returns the names of all registered transports
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the transport selected by the device
+func New(device *config.Device, model *config.Model, opts *Options) (Transport, error) {
+	registryMu.RLock()
+	factory, ok := registry[device.EffectiveTransport()]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", device.EffectiveTransport())
+	}
+	return factory(device, model, opts), nil
+}
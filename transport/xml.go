@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zinrai/netback/config"
+)
+
+// xmlNode is a minimal XML element tree that preserves prefixes and
+// namespace declarations exactly as received
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	// text holds character data for text nodes (name is empty)
+	text string
+}
+
+// formatXML rewrites an XML fragment in the given format (see config.Format*)
+func formatXML(data []byte, format string) (string, error) {
+	switch format {
+	case "", config.FormatRaw:
+		return string(data), nil
+	case config.FormatPretty, config.FormatCanonical:
+	default:
+		return "", fmt.Errorf("unknown xml format %q", format)
+	}
+
+	nodes, err := parseXML(data)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if format == config.FormatPretty {
+			writePretty(&buf, n, 0)
+		} else {
+			writeCanonical(&buf, n)
+		}
+	}
+	return buf.String(), nil
+}
+
+// parseXML builds element trees from a fragment, dropping comments,
+// processing instructions and whitespace between elements
+func parseXML(data []byte) ([]*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse xml: %w", err)
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: qualifiedName(t.Name), attrs: t.Attr}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("parse xml: unexpected </%s>", qualifiedName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if strings.TrimSpace(string(t)) == "" {
+				continue
+			}
+			parent.children = append(parent.children, &xmlNode{text: string(t)})
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("parse xml: unclosed <%s>", stack[len(stack)-1].name)
+	}
+	return root.children, nil
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// writePretty writes an element indented by two spaces per level;
+// elements containing only text stay on one line
+func writePretty(buf *bytes.Buffer, n *xmlNode, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	if n.name == "" {
+		buf.WriteString(indent)
+		xml.EscapeText(buf, []byte(strings.TrimSpace(n.text)))
+		buf.WriteString("\n")
+		return
+	}
+
+	buf.WriteString(indent)
+	writeStartTag(buf, n.name, n.attrs)
+
+	switch {
+	case len(n.children) == 0:
+	case len(n.children) == 1 && n.children[0].name == "":
+		xml.EscapeText(buf, []byte(n.children[0].text))
+	default:
+		buf.WriteString("\n")
+		for _, c := range n.children {
+			writePretty(buf, c, depth+1)
+		}
+		buf.WriteString(indent)
+	}
+
+	buf.WriteString("</" + n.name + ">\n")
+}
+
+// writeCanonical writes an element without insignificant whitespace,
+// with namespace declarations first and attributes sorted by name
+func writeCanonical(buf *bytes.Buffer, n *xmlNode) {
+	if n.name == "" {
+		xml.EscapeText(buf, []byte(n.text))
+		return
+	}
+
+	attrs := make([]xml.Attr, len(n.attrs))
+	copy(attrs, n.attrs)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		ni, nj := isNamespaceDecl(attrs[i]), isNamespaceDecl(attrs[j])
+		if ni != nj {
+			return ni
+		}
+		return qualifiedName(attrs[i].Name) < qualifiedName(attrs[j].Name)
+	})
+
+	writeStartTag(buf, n.name, attrs)
+	for _, c := range n.children {
+		writeCanonical(buf, c)
+	}
+	buf.WriteString("</" + n.name + ">")
+}
+
+func writeStartTag(buf *bytes.Buffer, name string, attrs []xml.Attr) {
+	buf.WriteString("<" + name)
+	for _, a := range attrs {
+		buf.WriteString(" " + qualifiedName(a.Name) + `="`)
+		xml.EscapeText(buf, []byte(a.Value))
+		buf.WriteString(`"`)
+	}
+	buf.WriteString(">")
+}
+
+func isNamespaceDecl(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}